@@ -0,0 +1,56 @@
+package main
+
+// SUBNET_AGGREGATION values, how the free IPs of the subnets of an ASG make
+// up its own
+const (
+	subnetAggregationSum = "sum"
+	subnetAggregationMin = "min"
+	subnetAggregationAvg = "avg"
+	subnetAggregationMax = "max"
+)
+
+// validSubnetAggregation reports whether SUBNET_AGGREGATION is supported
+func validSubnetAggregation() bool {
+	switch subnetAggregation {
+	case subnetAggregationSum, subnetAggregationMin, subnetAggregationAvg, subnetAggregationMax:
+		return true
+	}
+	return false
+}
+
+// aggregateFreeIPs combines the free IPs of the subnets of an ASG as set by
+// SUBNET_AGGREGATION. min scores the ASG on its most exhausted subnet, which
+// a sum hides
+func aggregateFreeIPs(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	result := values[0]
+	total := 0
+	for _, value := range values {
+		total += value
+		switch {
+		case subnetAggregation == subnetAggregationMin && value < result,
+			subnetAggregation == subnetAggregationMax && value > result:
+			result = value
+		}
+	}
+	switch subnetAggregation {
+	case subnetAggregationAvg:
+		return total / len(values)
+	case subnetAggregationMin, subnetAggregationMax:
+		return result
+	}
+	return total
+}
+
+// aggregateSubnets recomputes the free IPs of the ASGs from their subnets
+func aggregateSubnets(asgs []*asgInfo) {
+	for _, asg := range asgs {
+		values := make([]int, 0, len(asg.Subnets))
+		for _, freeIPs := range asg.Subnets {
+			values = append(values, freeIPs)
+		}
+		asg.FreeIPs = aggregateFreeIPs(values)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestAggregateFreeIPs(t *testing.T) {
+	defer func(aggregation string) { subnetAggregation = aggregation }(subnetAggregation)
+
+	values := []int{120, 30, 250, 0}
+	tests := []struct {
+		aggregation string
+		values      []int
+		want        int
+	}{
+		{aggregation: subnetAggregationSum, values: values, want: 400},
+		{aggregation: subnetAggregationMin, values: values, want: 0},
+		{aggregation: subnetAggregationAvg, values: values, want: 100},
+		{aggregation: subnetAggregationMax, values: values, want: 250},
+		{aggregation: subnetAggregationMin, values: []int{120, 30}, want: 30},
+		{aggregation: subnetAggregationAvg, values: []int{1, 2}, want: 1},
+		{aggregation: subnetAggregationMin, values: nil, want: 0},
+	}
+	for _, tt := range tests {
+		subnetAggregation = tt.aggregation
+		if got := aggregateFreeIPs(tt.values); got != tt.want {
+			t.Errorf("aggregateFreeIPs(%v) with %s = %d, want %d", tt.values, tt.aggregation, got, tt.want)
+		}
+	}
+}
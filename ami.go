@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// amiFilterEnabled reports whether any AMI_* include/exclude rule is set
+func amiFilterEnabled() bool {
+	return len(amiNameInclude) > 0 || len(amiNameExclude) > 0 || len(amiOwnerInclude) > 0 || len(amiOwnerExclude) > 0
+}
+
+// splitList splits a comma separated setting, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// matchesAny reports whether name matches one of the glob patterns
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// asgImage resolves the AMI the ASG's launch template boots, caching images
+// by id across the ASGs of a single run
+func asgImage(asg *asgInfo, images map[string]*ec2.Image) (*ec2.Image, error) {
+	if !asg.hasLaunchData() {
+		return nil, fmt.Errorf("no launch template or launch configuration")
+	}
+	data, err := describeLaunchTemplateData(asg)
+	if err != nil {
+		return nil, err
+	}
+	imageID := aws.StringValue(data.ImageId)
+	if imageID == "" {
+		return nil, fmt.Errorf("%s has no image", asg.launchName())
+	}
+	if image, ok := images[imageID]; ok {
+		return image, nil
+	}
+
+	output, err := asg.api().ec2.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(imageID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("image %s not found", imageID)
+	}
+	images[imageID] = output.Images[0]
+	return output.Images[0], nil
+}
+
+// amiRejection returns why the ASG's image isn't approved by the AMI_* rules,
+// or an empty string when it is. Images that can't be resolved are rejected
+func amiRejection(asg *asgInfo, images map[string]*ec2.Image) string {
+	image, err := asgImage(asg, images)
+	if err != nil {
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		return fmt.Sprintf("unable to resolve image: %v", err)
+	}
+
+	name := aws.StringValue(image.Name)
+	owner := aws.StringValue(image.OwnerId)
+	switch {
+	case len(amiOwnerInclude) > 0 && !matchesAny(owner, amiOwnerInclude):
+		return fmt.Sprintf("image %s owner %s is not allowed", aws.StringValue(image.ImageId), owner)
+	case matchesAny(owner, amiOwnerExclude):
+		return fmt.Sprintf("image %s owner %s is excluded", aws.StringValue(image.ImageId), owner)
+	case len(amiNameInclude) > 0 && !matchesAny(name, amiNameInclude):
+		return fmt.Sprintf("image %s (%s) is not allowed", aws.StringValue(image.ImageId), name)
+	case matchesAny(name, amiNameExclude):
+		return fmt.Sprintf("image %s (%s) is excluded", aws.StringValue(image.ImageId), name)
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// asgInfo is the data kept for a discovered ASG. Only what the later steps
+// need is copied out of the autoscaling.Group, so reconciles over huge
+// inventories don't retain every API response. The exported fields are what
+// external scorers receive
+type asgInfo struct {
+	Name           string `json:"name"`
+	LaunchTemplate string `json:"launchTemplate"`
+	// classic launch configuration, set instead of LaunchTemplate
+	LaunchConfiguration string `json:"launchConfiguration,omitempty"`
+	// launch templates of the MixedInstancesPolicy overrides that use their
+	// own
+	OverrideLaunchTemplates []string          `json:"overrideLaunchTemplates,omitempty"`
+	MinSize                 int64             `json:"minSize"`
+	MaxSize                 int64             `json:"maxSize"`
+	DesiredCapacity         int64             `json:"desiredCapacity"`
+	InServiceInstances      int64             `json:"inServiceInstances"`
+	Tags                    map[string]string `json:"tags"`
+	Subnets                 map[string]int    `json:"subnets"`
+	FreeIPs                 int               `json:"freeIPs"`
+	// EKS managed node group backed by the ASG, when discovered through
+	// EKS_NODEGROUPS
+	Nodegroup string `json:"nodegroup,omitempty"`
+	// account and region of the ASG when another discovered ASG has the same
+	// name, see key
+	Qualifier string `json:"qualifier,omitempty"`
+
+	subnetIDs             []string
+	launchTemplateSpec    *autoscaling.LaunchTemplateSpecification
+	overrideInstanceTypes []string
+	suspendedProcesses    []string
+	// override launch templates that don't set an instance type and use the
+	// one of the template
+	overrideTemplateSpecs []*autoscaling.LaunchTemplateSpecification
+	clients               *awsClients
+	// resolved launch template data, see describeLaunchTemplateData
+	launchData *ec2.ResponseLaunchTemplateData
+	// instances of the ASG in any lifecycle state
+	instances   int64
+	instanceIDs []string
+
+	// share of the capacity above the on-demand base launched as spot, 0 to 1
+	spotShare float64
+	// availability zone of each subnet in Subnets, set while measuring them
+	subnetZones map[string]string
+}
+
+// newASGInfo copies the fields we use out of an API response from the region
+// of the given clients
+func newASGInfo(group *autoscaling.Group, clients *awsClients) *asgInfo {
+	asg := &asgInfo{
+		clients:         clients,
+		Name:            aws.StringValue(group.AutoScalingGroupName),
+		MinSize:         aws.Int64Value(group.MinSize),
+		MaxSize:         aws.Int64Value(group.MaxSize),
+		DesiredCapacity: aws.Int64Value(group.DesiredCapacity),
+		Tags:            make(map[string]string, len(group.Tags)),
+	}
+
+	asg.instances = int64(len(group.Instances))
+	for _, instance := range group.Instances {
+		asg.instanceIDs = append(asg.instanceIDs, aws.StringValue(instance.InstanceId))
+		if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+			asg.InServiceInstances++
+		}
+	}
+
+	for _, process := range group.SuspendedProcesses {
+		asg.suspendedProcesses = append(asg.suspendedProcesses, aws.StringValue(process.ProcessName))
+	}
+
+	for _, tag := range group.Tags {
+		asg.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	if vpcZoneIdentifier := aws.StringValue(group.VPCZoneIdentifier); vpcZoneIdentifier != "" {
+		asg.subnetIDs = strings.Split(vpcZoneIdentifier, ",")
+	}
+
+	if group.LaunchTemplate != nil {
+		asg.launchTemplateSpec = group.LaunchTemplate
+	} else if group.MixedInstancesPolicy != nil && group.MixedInstancesPolicy.LaunchTemplate != nil {
+		asg.launchTemplateSpec = group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+		if distribution := group.MixedInstancesPolicy.InstancesDistribution; distribution != nil && distribution.OnDemandPercentageAboveBaseCapacity != nil {
+			asg.spotShare = float64(100-aws.Int64Value(distribution.OnDemandPercentageAboveBaseCapacity)) / 100
+		}
+		for _, override := range group.MixedInstancesPolicy.LaunchTemplate.Overrides {
+			if override.InstanceType != nil {
+				asg.overrideInstanceTypes = append(asg.overrideInstanceTypes, *override.InstanceType)
+			}
+			if spec := override.LaunchTemplateSpecification; spec != nil {
+				if name := aws.StringValue(spec.LaunchTemplateName); name != "" {
+					asg.OverrideLaunchTemplates = append(asg.OverrideLaunchTemplates, name)
+				}
+				if override.InstanceType == nil {
+					asg.overrideTemplateSpecs = append(asg.overrideTemplateSpecs, spec)
+				}
+			}
+		}
+	}
+	if asg.launchTemplateSpec != nil {
+		asg.LaunchTemplate = aws.StringValue(asg.launchTemplateSpec.LaunchTemplateName)
+	} else {
+		asg.LaunchConfiguration = aws.StringValue(group.LaunchConfigurationName)
+	}
+
+	return asg
+}
+
+// launchName returns the name of the launch template of the ASG, or of its
+// launch configuration when it uses one, which is what LT_CONTAINS matches
+func (a *asgInfo) launchName() string {
+	if a.LaunchConfiguration != "" {
+		return a.LaunchConfiguration
+	}
+	return a.LaunchTemplate
+}
+
+// launchNames returns the launch template or configuration name of the ASG
+// followed by the launch templates of its MixedInstancesPolicy overrides
+func (a *asgInfo) launchNames() []string {
+	return append([]string{a.launchName()}, a.OverrideLaunchTemplates...)
+}
+
+// hasLaunchData reports whether the ASG has a launch template or launch
+// configuration to resolve its image and instance type from
+func (a *asgInfo) hasLaunchData() bool {
+	return a.launchTemplateSpec != nil || a.LaunchConfiguration != ""
+}
+
+// inactiveReason returns why CA can't scale the ASG up, its MaxSize being 0
+// or its Launch process being suspended, or an empty string when it can
+func (a *asgInfo) inactiveReason() string {
+	if a.MaxSize == 0 {
+		return "MaxSize is 0"
+	}
+	for _, process := range a.suspendedProcesses {
+		if process == "Launch" {
+			return "Launch process suspended"
+		}
+	}
+	return ""
+}
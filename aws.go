@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const roleSessionName = "ca-autoconfig"
+
+// newAWSSession returns a session whose credentials are refreshed ahead of
+// their expiry, so a long running loop never ends up using expired
+// web-identity or assumed-role credentials
+func newAWSSession() *session.Session {
+	config := &aws.Config{Region: &setRegion, STSRegionalEndpoint: stsRegionalEndpoint()}
+	if len(endpointOverrides) > 0 {
+		config.EndpointResolver = endpointResolver()
+		// custom S3 endpoints rarely support virtual hosted buckets
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	sess := session.Must(session.NewSession(config))
+
+	// IRSA: renew the web identity credentials before they expire instead
+	// of waiting for a call to fail
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	webIdentityRole := os.Getenv("AWS_ROLE_ARN")
+	if tokenFile != "" && webIdentityRole != "" {
+		provider := stscreds.NewWebIdentityRoleProviderWithOptions(sts.New(sess), webIdentityRole, roleSessionName,
+			stscreds.FetchTokenPath(tokenFile), func(p *stscreds.WebIdentityRoleProvider) {
+				p.ExpiryWindow = credentialsExpiryWindow
+			})
+		sess = sess.Copy(&aws.Config{Credentials: credentials.NewCredentials(provider)})
+		if debug {
+			fmt.Println("DEBUG: using web identity credentials for " + webIdentityRole)
+		}
+	}
+
+	// optionally assume a role on top of the base credentials
+	if assumeRoleARN != "" {
+		creds := stscreds.NewCredentials(sess, assumeRoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = roleSessionName
+			p.Duration = assumeRoleDuration
+			p.ExpiryWindow = credentialsExpiryWindow
+		})
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+		if debug {
+			fmt.Println("DEBUG: assuming role " + assumeRoleARN)
+		}
+	}
+
+	return sess
+}
@@ -0,0 +1,51 @@
+package main
+
+import "math"
+
+// PRIORITY_NORMALIZATION values
+const (
+	priorityNormalizationNone  = "none"
+	priorityNormalizationBands = "bands"
+)
+
+// validPriorityNormalization reports whether PRIORITY_NORMALIZATION is
+// supported
+func validPriorityNormalization() bool {
+	return priorityNormalization == priorityNormalizationNone || priorityNormalization == priorityNormalizationBands
+}
+
+// bandScores maps the scores above DEMOTED_SCORE linearly onto the bands
+// between DEMOTED_SCORE+1 and PRIORITY_BAND_MAX, the best ASG on the top one
+// and the worst on the bottom one, so the ladder reads e.g. 100, 73, 3
+// rather than 8123, 8119, 41. Demoted ASGs keep their score
+func bandScores(scores map[string]int) map[string]int {
+	lowest, highest := 0, 0
+	first := true
+	for _, score := range scores {
+		if score <= demotedScore {
+			continue
+		}
+		if first || score < lowest {
+			lowest = score
+		}
+		if first || score > highest {
+			highest = score
+		}
+		first = false
+	}
+
+	bottom := demotedScore + 1
+	banded := make(map[string]int, len(scores))
+	for name, score := range scores {
+		switch {
+		case score <= demotedScore:
+			banded[name] = score
+		case highest == lowest:
+			banded[name] = priorityBandMax
+		default:
+			position := float64(score-lowest) / float64(highest-lowest)
+			banded[name] = bottom + int(math.Round(position*float64(priorityBandMax-bottom)))
+		}
+	}
+	return banded
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBandScores(t *testing.T) {
+	defer func(demoted, bandMax int) { demotedScore, priorityBandMax = demoted, bandMax }(demotedScore, priorityBandMax)
+	demotedScore, priorityBandMax = 2, 100
+
+	tests := []struct {
+		name   string
+		scores map[string]int
+		want   map[string]int
+	}{
+		{name: "empty", scores: map[string]int{}, want: map[string]int{}},
+		{
+			name:   "spread between the bands",
+			scores: map[string]int{"a": 8123, "b": 4082, "c": 41},
+			want:   map[string]int{"a": 100, "b": 52, "c": 3},
+		},
+		{
+			name:   "demoted keep their score",
+			scores: map[string]int{"a": 500, "b": 100, "demoted": 2, "zero": 0},
+			want:   map[string]int{"a": 100, "b": 3, "demoted": 2, "zero": 0},
+		},
+		{
+			name:   "all equal",
+			scores: map[string]int{"a": 70, "b": 70},
+			want:   map[string]int{"a": 100, "b": 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bandScores(tt.scores); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bandScores(%v) = %v, want %v", tt.scores, got, tt.want)
+			}
+		})
+	}
+}
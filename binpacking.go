@@ -0,0 +1,41 @@
+package main
+
+import "fmt"
+
+const scoringModeBinPacking = "bin-packing"
+
+// binPackingScorer ranks ASGs from the fewest free IPs to the most, filling
+// subnets up one after the other instead of spreading nodes over all of
+// them, e.g. to drain subnets being retired or keep large ones in reserve.
+// ASGs without MIN_FREE_IPS, or without a single free IP when it isn't set,
+// can't take a node and score DEMOTED_SCORE
+type binPackingScorer struct{}
+
+func (binPackingScorer) String() string { return scoringModeBinPacking }
+
+func (binPackingScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	sufficient := minFreeIPs
+	if sufficient < 1 {
+		sufficient = 1
+	}
+
+	top := 0
+	for _, asg := range asgs {
+		if freeIPs := quantizeFreeIPs(asg.FreeIPs); asg.FreeIPs >= sufficient && freeIPs > top {
+			top = freeIPs
+		}
+	}
+
+	scores := make(map[string]int, len(asgs))
+	for _, asg := range asgs {
+		if asg.FreeIPs < sufficient {
+			scores[asg.key()] = demotedScore
+		} else {
+			scores[asg.key()] = demotedScore + 1 + top - quantizeFreeIPs(asg.FreeIPs)
+		}
+		if debug {
+			fmt.Printf("DEBUG: %s has %d free IPs, bin-packing score %d\n", asg.Name, asg.FreeIPs, scores[asg.key()])
+		}
+	}
+	return scores, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const scoringModeCheapestFirst = "cheapest-first"
+
+// cheapestFirstScorer ranks ASGs from the lowest average on-demand price of
+// their instance types to the highest, free IPs ordering the ASGs with the
+// same price. ASGs without a known price come last
+type cheapestFirstScorer struct{}
+
+func (cheapestFirstScorer) String() string { return scoringModeCheapestFirst }
+
+func (cheapestFirstScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	cache := &factorCache{prices: make(map[string]float64)}
+	costs := make(map[string]float64, len(asgs))
+	var prices []float64
+	span := 0
+	for _, asg := range asgs {
+		cost, ok, err := asgHourlyCost(asg, cache)
+		if err != nil {
+			return nil, fmt.Errorf("resolving the price of ASG %s: %v", asg.Name, err)
+		}
+		if ok {
+			costs[asg.key()] = cost
+			prices = append(prices, cost)
+		}
+		if freeIPs := quantizeFreeIPs(asg.FreeIPs); freeIPs >= span {
+			span = freeIPs + 1
+		}
+	}
+	sort.Float64s(prices)
+
+	// every distinct price is a tier of span points, the cheapest on top
+	tiers := make(map[float64]int)
+	for _, price := range prices {
+		if _, ok := tiers[price]; !ok {
+			tiers[price] = len(tiers)
+		}
+	}
+
+	scores := make(map[string]int, len(asgs))
+	for _, asg := range asgs {
+		tier := 0
+		if cost, ok := costs[asg.key()]; ok {
+			tier = len(tiers) - tiers[cost]
+		}
+		scores[asg.key()] = tier*span + quantizeFreeIPs(asg.FreeIPs)
+		if debug {
+			fmt.Printf("DEBUG: %s costs %.4f/h, score %d\n", asg.Name, costs[asg.key()], scores[asg.key()])
+		}
+	}
+	return scores, nil
+}
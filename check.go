@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// kubePermission is a Kubernetes API access the controller needs
+type kubePermission struct {
+	verb      string
+	group     string
+	resource  string
+	namespace string
+}
+
+// requiredIAMActions returns the IAM actions needed by the enabled features
+func requiredIAMActions() []string {
+	actions := map[string]bool{
+		"autoscaling:DescribeAutoScalingGroups": true,
+		"ec2:DescribeSubnets":                   true,
+	}
+	if subnetCIDRReservations {
+		actions["ec2:GetSubnetCidrReservations"] = true
+	}
+	if manageCATags {
+		actions["autoscaling:CreateOrUpdateTags"] = true
+		actions["autoscaling:DeleteTags"] = true
+	}
+	if syncNodeTags || auditZero {
+		actions["ec2:DescribeLaunchTemplateVersions"] = true
+		actions["ec2:DescribeInstanceTypes"] = true
+		actions["eks:DescribeNodegroup"] = true
+	}
+	if syncNodeTags {
+		actions["autoscaling:CreateOrUpdateTags"] = true
+	}
+	if eksNodegroups {
+		actions["eks:ListNodegroups"] = true
+		actions["eks:DescribeNodegroup"] = true
+	}
+	if instanceRefreshAction != "" {
+		actions["autoscaling:DescribeInstanceRefreshes"] = true
+	}
+	if deferDuringScaling || learnOutcomes {
+		actions["autoscaling:DescribeScalingActivities"] = true
+	}
+	if previousGenerationPenalty > 0 {
+		actions["ec2:DescribeLaunchTemplateVersions"] = true
+		actions["ec2:DescribeInstanceTypes"] = true
+	}
+	if checkServiceQuotas {
+		actions["ec2:DescribeInstances"] = true
+		actions["ec2:DescribeLaunchTemplateVersions"] = true
+		actions["servicequotas:GetServiceQuota"] = true
+		actions["ec2:DescribeNetworkInterfaces"] = true
+	}
+	if edgeSubnets != "" {
+		actions["ec2:DescribeAvailabilityZones"] = true
+	}
+	if prefixDelegation || observeNodeIPs {
+		actions["ec2:DescribeNetworkInterfaces"] = true
+	}
+	if validateSubnetRoutes {
+		actions["ec2:DescribeRouteTables"] = true
+	}
+	if scoringMode == scoringModeSpotFirst || spotInterruptionThreshold > 0 {
+		actions["ec2:DescribeLaunchTemplateVersions"] = true
+	}
+	if scoringMode == scoringModeCheapestFirst {
+		actions["ec2:DescribeLaunchTemplateVersions"] = true
+		actions["pricing:GetProducts"] = true
+	}
+	if scoringMode == scoringModeWeighted {
+		weights, _ := parseScoringWeights(scoringWeights)
+		if weights[factorCost] > 0 || weights[factorSpotInterruption] > 0 || weights[factorSpotPrice] > 0 {
+			actions["ec2:DescribeLaunchTemplateVersions"] = true
+		}
+		if weights[factorSpotPrice] > 0 {
+			actions["ec2:DescribeSpotPriceHistory"] = true
+		}
+		if weights[factorCost] > 0 {
+			actions["pricing:GetProducts"] = true
+		}
+	}
+	if instanceTypeFilterEnabled() || gpuNodeGroups == gpuNodeGroupsDemote || scoringMode == scoringModeNodeHeadroom || subtractPendingInstances {
+		actions["ec2:DescribeLaunchTemplateVersions"] = true
+		actions["ec2:DescribeInstanceTypes"] = true
+	}
+	if amiFilterEnabled() {
+		actions["ec2:DescribeLaunchTemplateVersions"] = true
+		actions["ec2:DescribeImages"] = true
+	}
+	if cloudWatchPublish {
+		actions["cloudwatch:PutMetricData"] = true
+	}
+	if organizationRoleName != "" {
+		actions["organizations:ListAccounts"] = true
+	}
+	if assumeRoleARN != "" || len(assumeRoleARNs) > 0 || organizationRoleName != "" || len(sharedSubnetRoleARNs) > 0 {
+		actions["sts:AssumeRole"] = true
+	}
+	if strings.HasPrefix(rulesSource, "s3://") || strings.HasPrefix(rulesSHA256Source, "s3://") {
+		actions["s3:GetObject"] = true
+	}
+	if actions["ec2:DescribeLaunchTemplateVersions"] {
+		// ASGs using launch configurations are resolved through autoscaling
+		actions["autoscaling:DescribeLaunchConfigurations"] = true
+	}
+
+	list := make([]string, 0, len(actions))
+	for action := range actions {
+		list = append(list, action)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// requiredKubePermissions returns the Kubernetes accesses needed by the
+// enabled features
+func requiredKubePermissions() []kubePermission {
+	permissions := []kubePermission{
+		{verb: "get", resource: "configmaps", namespace: caNamespace},
+		{verb: "update", resource: "configmaps", namespace: caNamespace},
+		{verb: "create", resource: "events", namespace: caNamespace},
+	}
+	if !skipCMCreation || learnOutcomes {
+		permissions = append(permissions, kubePermission{verb: "create", resource: "configmaps", namespace: caNamespace})
+	}
+	if checkKarpenter {
+		permissions = append(permissions, kubePermission{verb: "list", group: "karpenter.k8s.aws", resource: "ec2nodeclasses"})
+	}
+	if migrateFromName != "" {
+		permissions = append(permissions,
+			kubePermission{verb: "get", resource: "configmaps", namespace: migrateFromNamespace},
+			kubePermission{verb: "update", resource: "configmaps", namespace: migrateFromNamespace},
+		)
+	}
+	if heartbeatLease != "" {
+		permissions = append(permissions,
+			kubePermission{verb: "get", group: "coordination.k8s.io", resource: "leases", namespace: caNamespace},
+			kubePermission{verb: "create", group: "coordination.k8s.io", resource: "leases", namespace: caNamespace},
+			kubePermission{verb: "update", group: "coordination.k8s.io", resource: "leases", namespace: caNamespace},
+		)
+	}
+	if deferDuringScaling || learnOutcomes {
+		permissions = append(permissions, kubePermission{verb: "list", resource: "nodes"})
+	}
+	if customNetworking {
+		permissions = append(permissions, kubePermission{verb: "list", group: eniConfigResource.Group, resource: eniConfigResource.Resource})
+	}
+	return permissions
+}
+
+// assumedRolePattern matches the STS ARN of an assumed role session
+var assumedRolePattern = regexp.MustCompile(`^arn:([^:]+):sts::(\d+):assumed-role/([^/]+)/.+$`)
+
+// principalARN returns the IAM ARN of the identity we are running as,
+// resolving assumed role sessions to their role
+func principalARN() (string, error) {
+	identity, err := sts.New(awsSession).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+
+	match := assumedRolePattern.FindStringSubmatch(*identity.Arn)
+	if match == nil {
+		return *identity.Arn, nil
+	}
+
+	// the STS ARN drops the role path, ask IAM for the real one
+	role, err := iam.New(awsSession).GetRole(&iam.GetRoleInput{RoleName: aws.String(match[3])})
+	if err != nil {
+		return "", err
+	}
+	return *role.Role.Arn, nil
+}
+
+// checkIAMPermissions simulates the required actions against our principal
+func checkIAMPermissions() bool {
+	principal, err := principalARN()
+	if err != nil {
+		fmt.Printf("FAIL  unable to determine AWS principal: %v\n", err)
+		return false
+	}
+	fmt.Println("AWS principal: " + principal)
+
+	passed := true
+	err = iam.New(awsSession).SimulatePrincipalPolicyPages(&iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principal),
+		ActionNames:     aws.StringSlice(requiredIAMActions()),
+	}, func(page *iam.SimulatePolicyResponse, lastPage bool) bool {
+		for _, result := range page.EvaluationResults {
+			if *result.EvalDecision == "allowed" {
+				fmt.Printf("PASS  %s\n", *result.EvalActionName)
+			} else {
+				fmt.Printf("FAIL  %s (%s)\n", *result.EvalActionName, *result.EvalDecision)
+				passed = false
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		fmt.Printf("FAIL  unable to simulate IAM policy: %v\n", err)
+		return false
+	}
+	return passed
+}
+
+// checkKubePermissions runs a SelfSubjectAccessReview for every required
+// Kubernetes access
+func checkKubePermissions(clientset kubernetes.Interface) bool {
+	passed := true
+	for _, permission := range requiredKubePermissions() {
+		description := fmt.Sprintf("%s %s", permission.verb, permission.resource)
+		if permission.group != "" {
+			description += "." + permission.group
+		}
+		if permission.namespace != "" {
+			description += " in " + permission.namespace
+		}
+
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.Background(), &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: permission.namespace,
+					Verb:      permission.verb,
+					Group:     permission.group,
+					Resource:  permission.resource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL  %s: %v\n", description, err)
+			passed = false
+		case review.Status.Allowed:
+			fmt.Printf("PASS  %s\n", description)
+		default:
+			fmt.Printf("FAIL  %s %s\n", description, review.Status.Reason)
+			passed = false
+		}
+	}
+	return passed
+}
+
+// runCheck prints a pass/fail report of every AWS and Kubernetes permission
+// the current configuration needs, returning the process exit code
+func runCheck() int {
+	fmt.Println("Checking AWS permissions...")
+	awsPassed := checkIAMPermissions()
+
+	fmt.Println("Checking Kubernetes permissions...")
+	kubePassed := false
+	if _, clientset, err := newKubernetesClient(); err != nil {
+		fmt.Printf("FAIL  unable to create Kubernetes client: %v\n", err)
+	} else {
+		kubePassed = checkKubePermissions(clientset)
+	}
+
+	if awsPassed && kubePassed {
+		fmt.Println("All checks passed")
+		return exitOK
+	}
+	fmt.Println("Some checks failed")
+	return exitValidationError
+}
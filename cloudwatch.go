@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// cloudWatchBatchSize bounds the data sent by a single PutMetricData call
+const cloudWatchBatchSize = 1000
+
+// cloudWatchMetrics maps the free IP metrics pushed to CloudWatch to their
+// CloudWatch names
+var cloudWatchMetrics = map[string]string{
+	metricASGFreeIPs:    "ASGFreeIPs",
+	metricSubnetFreeIPs: "SubnetFreeIPs",
+}
+
+// cloudWatchDimensions maps metric labels to CloudWatch dimension names
+var cloudWatchDimensions = map[string]string{
+	"asg":     "AutoScalingGroupName",
+	"subnet":  "SubnetId",
+	"zone":    "AvailabilityZone",
+	"profile": "Profile",
+}
+
+// cloudWatchDatum converts a free IP sample into a CloudWatch datum with the
+// CLOUDWATCH_DIMENSIONS added to its labels
+func cloudWatchDatum(sample metricSample, now time.Time) *cloudwatch.MetricDatum {
+	var dimensions []*cloudwatch.Dimension
+	for key, value := range sample.labels {
+		name, ok := cloudWatchDimensions[key]
+		if !ok {
+			name = key
+		}
+		dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)})
+	}
+	for _, entry := range splitList(cloudWatchExtraDimensions) {
+		if parts := strings.SplitN(entry, "=", 2); len(parts) == 2 {
+			dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String(strings.TrimSpace(parts[0])), Value: aws.String(strings.TrimSpace(parts[1]))})
+		}
+	}
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(cloudWatchMetrics[sample.name]),
+		Dimensions: dimensions,
+		Timestamp:  aws.Time(now),
+		Unit:       aws.String(cloudwatch.StandardUnitCount),
+		Value:      aws.Float64(sample.value),
+	}
+}
+
+// publishCloudWatch pushes the free IPs of the ASGs and subnets measured by
+// the last run to CloudWatch as custom metrics in CLOUDWATCH_NAMESPACE
+func publishCloudWatch() {
+	now := time.Now()
+	var data []*cloudwatch.MetricDatum
+	for name := range cloudWatchMetrics {
+		for _, sample := range metrics.samples(name) {
+			data = append(data, cloudWatchDatum(sample, now))
+		}
+	}
+
+	client := cloudwatch.New(awsSession)
+	for first := 0; first < len(data); first += cloudWatchBatchSize {
+		last := first + cloudWatchBatchSize
+		if last > len(data) {
+			last = len(data)
+		}
+		_, err := client.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(cloudWatchNamespace),
+			MetricData: data[first:last],
+		})
+		if err != nil {
+			fmt.Printf("Unable to send metrics to CloudWatch: %v\n", err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "cloudwatch"}, 1)
+			return
+		}
+	}
+}
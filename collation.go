@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// name collations accepted by NAME_COLLATION
+const (
+	collationLexical   = "lexical"
+	collationNatural   = "natural"
+	collationCaseless  = "caseless"
+	collationByDefault = collationLexical
+)
+
+// validCollation reports whether NAME_COLLATION is one we know
+func validCollation() bool {
+	switch nameCollation {
+	case collationLexical, collationNatural, collationCaseless:
+		return true
+	}
+	return false
+}
+
+// sortNames sorts the names of a priority tier in place using NAME_COLLATION
+// so the rendered document doesn't change between runs with the same data
+func sortNames(names []string) {
+	sort.SliceStable(names, func(i, j int) bool {
+		switch nameCollation {
+		case collationNatural:
+			return naturalLess(names[i], names[j])
+		case collationCaseless:
+			a, b := strings.ToLower(names[i]), strings.ToLower(names[j])
+			if a != b {
+				return a < b
+			}
+		}
+		return names[i] < names[j]
+	})
+}
+
+// naturalLess compares strings treating runs of digits as numbers, so
+// workers-2 sorts before workers-10
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// leadingDigits returns the run of ASCII digits s starts with
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
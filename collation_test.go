@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "workers-2", b: "workers-10", want: true},
+		{a: "workers-10", b: "workers-2", want: false},
+		{a: "workers-02", b: "workers-10", want: true},
+		{a: "workers-007", b: "workers-7", want: false},
+		{a: "workers", b: "workers-1", want: true},
+		{a: "gpu-1", b: "workers-1", want: true},
+		{a: "a1b2", b: "a1b10", want: true},
+		{a: "same", b: "same", want: false},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSortNames(t *testing.T) {
+	defer func(collation string) { nameCollation = collation }(nameCollation)
+
+	tests := []struct {
+		collation string
+		want      []string
+	}{
+		{collation: collationByDefault, want: []string{"Workers-3", "workers-10", "workers-2"}},
+		{collation: collationNatural, want: []string{"Workers-3", "workers-2", "workers-10"}},
+		{collation: collationCaseless, want: []string{"workers-10", "workers-2", "Workers-3"}},
+	}
+	for _, tt := range tests {
+		nameCollation = tt.collation
+		names := []string{"workers-10", "Workers-3", "workers-2"}
+		sortNames(names)
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("sortNames() with %s collation = %v, want %v", tt.collation, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// findNameCollisions returns, for every ASG name that would also match an ASG
+// placed on a different priority, the list of names it collides with. CA
+// treats each entry as a regular expression, so "workers" at one priority
+// also matches "workers-spot" at another. Matched ASGs left out by TOP_N
+// count as well, as an entry matching them would list them after all
+func findNameCollisions(caPriorities map[int][]string, matched []*asgInfo) map[string][]string {
+	priorityOf := make(map[string]int)
+	for priority, names := range caPriorities {
+		for _, name := range names {
+			priorityOf[name] = priority
+		}
+	}
+	unlisted := make(map[string]bool)
+	for _, asg := range matched {
+		if _, ok := priorityOf[asg.Name]; !ok {
+			unlisted[asg.Name] = true
+		}
+	}
+
+	collisions := make(map[string][]string)
+	for name, priority := range priorityOf {
+		re, err := regexp.Compile(name)
+		if err != nil {
+			continue
+		}
+		for other, otherPriority := range priorityOf {
+			if other == name || otherPriority == priority {
+				continue
+			}
+			if re.MatchString(other) {
+				collisions[name] = append(collisions[name], other)
+			}
+		}
+		for other := range unlisted {
+			if re.MatchString(other) {
+				collisions[name] = append(collisions[name], other)
+			}
+		}
+		sort.Strings(collisions[name])
+	}
+	return collisions
+}
+
+// anchoredPattern returns a regular expression matching only the given name
+func anchoredPattern(name string) string {
+	return "^" + regexp.QuoteMeta(name) + "$"
+}
+
+// reportNameCollisions logs and records every collision found
+func reportNameCollisions(collisions map[string][]string, status statusReport) []string {
+	var messages []string
+	for name, others := range collisions {
+		message := fmt.Sprintf("entry %s also matches ASGs on other priorities or left out by TOP_N: %s", name, strings.Join(others, ", "))
+		if anchorEntries || anchorCollisions {
+			message += " (anchored)"
+		}
+		fmt.Println(message)
+		status.add("nameCollisions", name)
+		messages = append(messages, message)
+	}
+	sort.Strings(messages)
+	return messages
+}
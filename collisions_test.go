@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindNameCollisions(t *testing.T) {
+	tests := []struct {
+		name         string
+		caPriorities map[int][]string
+		matched      []string
+		want         map[string][]string
+	}{
+		{
+			name:         "different priorities",
+			caPriorities: map[int][]string{30: {"workers"}, 20: {"workers-spot"}},
+			matched:      []string{"workers", "workers-spot"},
+			want:         map[string][]string{"workers": {"workers-spot"}},
+		},
+		{
+			name:         "same priority",
+			caPriorities: map[int][]string{30: {"workers", "workers-spot"}},
+			matched:      []string{"workers", "workers-spot"},
+			want:         map[string][]string{},
+		},
+		{
+			name:         "left out by TOP_N",
+			caPriorities: map[int][]string{30: {"workers"}, 20: {"batch"}},
+			matched:      []string{"workers", "batch", "workers-spot", "batch-gpu", "other"},
+			want:         map[string][]string{"workers": {"workers-spot"}, "batch": {"batch-gpu"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matched []*asgInfo
+			for _, name := range tt.matched {
+				matched = append(matched, &asgInfo{Name: name})
+			}
+			got := findNameCollisions(tt.caPriorities, matched)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findNameCollisions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	setRegion           string
+	caNamespace         string
+	caPriorityExpander  string
+	asgContains         string
+	ltContains          string
+	sleepMinutes        int
+	loopSleep           time.Duration
+	catchAll            bool
+	debug               bool
+	skipCMCreation      bool
+	manageCATags        bool
+	clusterName         string
+	syncNodeTags        bool
+	auditZero           bool
+	anchorCollisions    bool
+	anchorEntries       bool
+	checkKarpenter      bool
+	karpenterAPIVersion string
+	karpenterMinFreeIPs int
+	externalMetricsAddr string
+	externalMetricsCert string
+	externalMetricsKey  string
+	datadogMetrics      bool
+	datadogAgentHost    string
+	datadogStatsDPort   string
+	datadogTags         string
+	statsdAddr          string
+	statsdPrefix        string
+
+	externalMetricsClientCA string
+
+	assumeRoleARN           string
+	assumeRoleDuration      time.Duration
+	credentialsExpiryWindow time.Duration
+
+	scoringWebhookURL string
+	scoringExec       string
+	scoringTimeout    time.Duration
+
+	gitOpsMode string
+
+	retryInitialBackoff time.Duration
+	retryMaxBackoff     time.Duration
+
+	schedule         *cronSchedule
+	scheduleExpr     string
+	scheduleTimezone string
+
+	deferDuringScaling  bool
+	scalingSettleWindow time.Duration
+	scalingDeferMax     time.Duration
+
+	rolloutMaxChanges int
+
+	nameCollation string
+
+	amiNameInclude  []string
+	amiNameExclude  []string
+	amiOwnerInclude []string
+	amiOwnerExclude []string
+
+	previousGenerationPenalty int
+
+	topN int
+
+	metricsTextfile string
+
+	profiles []string
+
+	validateSubnetRoutes bool
+
+	demotedScore          int
+	checkServiceQuotas    bool
+	quotaThresholdPercent int
+
+	outputFormat string
+	outputFile   string
+
+	learnOutcomes     bool
+	outcomesConfigMap string
+
+	shadowScoring string
+
+	sharedSubnetAccounting string
+
+	heartbeatLease         string
+	heartbeatLeaseDuration time.Duration
+
+	migrateFromNamespace string
+	migrateFromName      string
+	migrateUntil         time.Time
+
+	otlpEndpoint    string
+	otlpHeaders     string
+	otlpServiceName string
+
+	asgMatchPattern string
+	asgMatchRegex   *regexp.Regexp
+
+	asgContainsPatterns []string
+
+	asgExcludesValue string
+	asgExcludes      []asgExclusion
+
+	asgTagSelectorValue string
+	asgTagSelector      []tagRequirement
+
+	caAutoDiscovery bool
+
+	eksNodegroups               bool
+	nodegroupContains           []string
+	nodegroupLabelSelectorValue string
+	nodegroupLabelSelector      []tagRequirement
+
+	regions []string
+
+	assumeRoleARNs []string
+
+	organizationRoleName string
+
+	ltMatchPattern string
+	ltMatchRegex   *regexp.Regexp
+
+	instanceTypeInclude []string
+	instanceTypeExclude []string
+
+	architectureInclude []string
+	architectureExclude []string
+
+	gpuNodeGroups string
+
+	instanceRefreshAction string
+
+	ignoreTag string
+
+	endpointOverrides map[string]string
+
+	partitionOverride    string
+	stsRegionalEndpoints string
+
+	subnetCacheTTL     time.Duration
+	subnetCacheRefresh bool
+
+	subnetCIDRReservations bool
+
+	ipFamily string
+
+	prefixDelegation bool
+
+	scoringMode    string
+	nodeMaxPods    int
+	nodeIPOverhead int
+	observeNodeIPs bool
+
+	customNetworking      bool
+	eniConfigMappingValue string
+	eniConfigMapping      map[string]string
+
+	subnetWeightTag string
+
+	subnetExcludeTagsValue string
+	subnetExcludeTags      []tagRequirement
+
+	azAllow []string
+	azDeny  []string
+
+	subtractPendingInstances bool
+
+	subnetAggregation string
+
+	freeIPsAsPercent bool
+
+	cloudWatchPublish         bool
+	cloudWatchNamespace       string
+	cloudWatchExtraDimensions string
+
+	subnetLowIPThreshold int
+
+	sharedSubnetRoleARNs []string
+
+	edgeSubnets string
+
+	exhaustionHorizon time.Duration
+	exhaustionWindow  time.Duration
+
+	smoothingRuns int
+
+	stepSize int
+
+	scoringWeights string
+	spotAdvisorURL string
+
+	spotInterruptionThreshold int
+
+	priorityNormalization string
+	priorityBandMax       int
+
+	hysteresisValue string
+
+	minFreeIPs       int
+	minFreeIPsAction string
+
+	catchAllPriority int
+	catchAllPattern  string
+
+	staticEntriesValue string
+
+	rulesSource       string
+	rulesSHA256       string
+	rulesSHA256Source string
+	rulesRefresh      time.Duration
+)
+
+// loadConfig parses every setting using the given lookup, which is the
+// environment optionally overlaid with the rules document. REGION falls back
+// to AWS_REGION, AWS_DEFAULT_REGION and the instance metadata. REGION,
+// REGIONS, the AWS credentials and endpoint settings (ASSUME_ROLE_ARNS and
+// ORGANIZATION_ROLE_NAME included) and the metrics listeners are only
+// honoured at startup
+func loadConfig(getenv func(string) string) {
+	setRegion = getenv("REGION")
+	if setRegion == "" {
+		setRegion = getenv("AWS_REGION")
+	}
+	if setRegion == "" {
+		setRegion = getenv("AWS_DEFAULT_REGION")
+	}
+	if setRegion == "" {
+		setRegion = detectedRegion
+	}
+	regions = splitList(getenv("REGIONS"))
+	assumeRoleARNs = splitList(getenv("ASSUME_ROLE_ARNS"))
+	organizationRoleName = getenv("ORGANIZATION_ROLE_NAME")
+	endpointOverrides = loadEndpointOverrides(getenv)
+	partitionOverride = getenv("AWS_PARTITION")
+	stsRegionalEndpoints = getenv("STS_REGIONAL_ENDPOINTS")
+	caNamespace = getenv("CA_NAMESPACE")
+	caPriorityExpander = getenv("CONFIGMAP_NAME")
+	if caPriorityExpander == "" {
+		caPriorityExpander = "cluster-autoscaler-priority-expander"
+	}
+	asgContains = getenv("ASG_CONTAINS")
+	ltContains = getenv("LT_CONTAINS")
+	sleepMinutes, _ = strconv.Atoi(getenv("SLEEP_MINUTES"))
+	loopSleep = time.Duration(sleepMinutes) * time.Minute
+	catchAll, _ = strconv.ParseBool(getenv("CATCH_ALL"))
+	debug, _ = strconv.ParseBool(getenv("DEBUG"))
+	skipCMCreation, _ = strconv.ParseBool(getenv("SKIP_CM_CREATION"))
+	manageCATags, _ = strconv.ParseBool(getenv("MANAGE_CA_TAGS"))
+	clusterName = getenv("CLUSTER_NAME")
+	syncNodeTags, _ = strconv.ParseBool(getenv("SYNC_NODE_TEMPLATE_TAGS"))
+	auditZero, _ = strconv.ParseBool(getenv("AUDIT_SCALE_FROM_ZERO"))
+	anchorCollisions, _ = strconv.ParseBool(getenv("ANCHOR_COLLISIONS"))
+	anchorEntries, _ = strconv.ParseBool(getenv("ANCHOR_ENTRIES"))
+	checkKarpenter, _ = strconv.ParseBool(getenv("CHECK_KARPENTER"))
+	karpenterAPIVersion = getenv("KARPENTER_API_VERSION")
+	if karpenterAPIVersion == "" {
+		karpenterAPIVersion = "v1"
+	}
+	karpenterMinFreeIPs, _ = strconv.Atoi(getenv("KARPENTER_MIN_FREE_IPS"))
+	if getenv("KARPENTER_MIN_FREE_IPS") == "" {
+		karpenterMinFreeIPs = 16
+	}
+	externalMetricsAddr = getenv("EXTERNAL_METRICS_ADDR")
+	externalMetricsCert = getenv("EXTERNAL_METRICS_TLS_CERT")
+	externalMetricsKey = getenv("EXTERNAL_METRICS_TLS_KEY")
+	externalMetricsClientCA = getenv("EXTERNAL_METRICS_CLIENT_CA")
+	datadogMetrics, _ = strconv.ParseBool(getenv("DATADOG_METRICS"))
+	datadogAgentHost = getenv("DD_AGENT_HOST")
+	if datadogAgentHost == "" {
+		datadogAgentHost = "localhost"
+	}
+	datadogStatsDPort = getenv("DD_DOGSTATSD_PORT")
+	if datadogStatsDPort == "" {
+		datadogStatsDPort = "8125"
+	}
+	datadogTags = getenv("DD_TAGS")
+	statsdAddr = getenv("STATSD_ADDR")
+	statsdPrefix = getenv("STATSD_PREFIX")
+
+	assumeRoleARN = getenv("ASSUME_ROLE_ARN")
+	assumeRoleDuration = parseDurationEnv(getenv("ASSUME_ROLE_DURATION"), time.Hour)
+	credentialsExpiryWindow = parseDurationEnv(getenv("CREDENTIALS_EXPIRY_WINDOW"), 5*time.Minute)
+
+	scoringWebhookURL = getenv("SCORING_WEBHOOK_URL")
+	scoringExec = getenv("SCORING_EXEC")
+	scoringTimeout = parseDurationEnv(getenv("SCORING_TIMEOUT"), scoringTimeoutDefault)
+
+	gitOpsMode = getenv("GITOPS_MODE")
+
+	retryInitialBackoff = parseDurationEnv(getenv("RETRY_INITIAL_BACKOFF"), 5*time.Second)
+	retryMaxBackoff = parseDurationEnv(getenv("RETRY_MAX_BACKOFF"), 5*time.Minute)
+
+	scheduleExpr = getenv("SCHEDULE")
+	scheduleTimezone = getenv("SCHEDULE_TIMEZONE")
+	schedule = nil
+	if scheduleExpr != "" {
+		// errors are reported by validateConfig
+		schedule, _ = parseCron(scheduleExpr, scheduleTimezone)
+	}
+
+	deferDuringScaling, _ = strconv.ParseBool(getenv("DEFER_DURING_SCALING"))
+	scalingSettleWindow = parseDurationEnv(getenv("SCALING_SETTLE_WINDOW"), 5*time.Minute)
+	scalingDeferMax = parseDurationEnv(getenv("SCALING_DEFER_MAX"), 30*time.Minute)
+
+	rolloutMaxChanges, _ = strconv.Atoi(getenv("ROLLOUT_MAX_CHANGES"))
+
+	nameCollation = getenv("NAME_COLLATION")
+	if nameCollation == "" {
+		nameCollation = collationByDefault
+	}
+
+	amiNameInclude = splitList(getenv("AMI_NAME_INCLUDE"))
+	amiNameExclude = splitList(getenv("AMI_NAME_EXCLUDE"))
+	amiOwnerInclude = splitList(getenv("AMI_OWNER_INCLUDE"))
+	amiOwnerExclude = splitList(getenv("AMI_OWNER_EXCLUDE"))
+
+	previousGenerationPenalty, _ = strconv.Atoi(getenv("PREVIOUS_GENERATION_PENALTY"))
+
+	topN, _ = strconv.Atoi(getenv("TOP_N"))
+
+	metricsTextfile = getenv("METRICS_TEXTFILE")
+
+	profiles = splitList(getenv("PROFILES"))
+
+	validateSubnetRoutes, _ = strconv.ParseBool(getenv("VALIDATE_SUBNET_ROUTES"))
+
+	demotedScore = 2
+	if value, err := strconv.Atoi(getenv("DEMOTED_SCORE")); err == nil {
+		demotedScore = value
+	}
+	checkServiceQuotas, _ = strconv.ParseBool(getenv("CHECK_SERVICE_QUOTAS"))
+	quotaThresholdPercent, _ = strconv.Atoi(getenv("QUOTA_THRESHOLD_PERCENT"))
+	if quotaThresholdPercent == 0 {
+		quotaThresholdPercent = 90
+	}
+
+	outputFormat = getenv("OUTPUT_FORMAT")
+	if outputFormat == "" {
+		outputFormat = outputConfigMap
+	}
+	outputFile = getenv("OUTPUT_FILE")
+
+	learnOutcomes, _ = strconv.ParseBool(getenv("LEARN_OUTCOMES"))
+	outcomesConfigMap = getenv("OUTCOMES_CONFIGMAP")
+	if outcomesConfigMap == "" {
+		outcomesConfigMap = "ca-autoconfig-outcomes"
+	}
+
+	shadowScoring = getenv("SHADOW_SCORING")
+
+	sharedSubnetAccounting = getenv("SHARED_SUBNET_ACCOUNTING")
+	if sharedSubnetAccounting == "" {
+		sharedSubnetAccounting = sharedSubnetsFull
+	}
+
+	heartbeatLease = getenv("HEARTBEAT_LEASE")
+	heartbeatLeaseDuration = parseDurationEnv(getenv("HEARTBEAT_LEASE_DURATION"), 0)
+
+	migrateFromNamespace, migrateFromName = parseMigrateFrom(getenv("MIGRATE_FROM"))
+	// errors are reported by validateMigration
+	migrateUntil, _ = time.Parse(time.RFC3339, getenv("MIGRATE_UNTIL"))
+
+	// the standard OpenTelemetry exporter variables
+	otlpEndpoint = getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
+	if base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); otlpEndpoint == "" && base != "" {
+		otlpEndpoint = strings.TrimSuffix(base, "/") + "/v1/metrics"
+	}
+	otlpHeaders = getenv("OTEL_EXPORTER_OTLP_HEADERS")
+	otlpServiceName = getenv("OTEL_SERVICE_NAME")
+	if otlpServiceName == "" {
+		otlpServiceName = eventComponent
+	}
+
+	asgContainsPatterns = splitList(asgContains)
+	asgMatchPattern = getenv("ASG_MATCH_REGEX")
+	// errors are reported by validateConfig
+	asgMatchRegex, _ = compileMatchRegex("ASG_MATCH_REGEX", asgMatchPattern)
+	ltMatchPattern = getenv("LT_MATCH_REGEX")
+	ltMatchRegex, _ = compileMatchRegex("LT_MATCH_REGEX", ltMatchPattern)
+	instanceTypeInclude = splitList(getenv("INSTANCE_TYPE_INCLUDE"))
+	instanceTypeExclude = splitList(getenv("INSTANCE_TYPE_EXCLUDE"))
+	architectureInclude = splitList(getenv("ARCHITECTURE_INCLUDE"))
+	architectureExclude = splitList(getenv("ARCHITECTURE_EXCLUDE"))
+	gpuNodeGroups = getenv("GPU_NODE_GROUPS")
+	if gpuNodeGroups == "" {
+		gpuNodeGroups = gpuNodeGroupsInclude
+	}
+	instanceRefreshAction = getenv("INSTANCE_REFRESH_ACTION")
+	ignoreTag = getenv("IGNORE_TAG")
+	if ignoreTag == "" {
+		ignoreTag = "ca-autoconfig/ignore"
+	}
+	subnetCacheTTL = parseDurationEnv(getenv("SUBNET_CACHE_TTL"), 0)
+	subnetCacheRefresh, _ = strconv.ParseBool(getenv("SUBNET_CACHE_REFRESH"))
+	subnetCIDRReservations = true
+	if value, err := strconv.ParseBool(getenv("SUBNET_CIDR_RESERVATIONS")); err == nil {
+		subnetCIDRReservations = value
+	}
+	ipFamily = getenv("IP_FAMILY")
+	if ipFamily == "" {
+		ipFamily = ipFamilyIPv4
+	}
+	prefixDelegation, _ = strconv.ParseBool(getenv("PREFIX_DELEGATION"))
+	scoringMode = getenv("SCORING_MODE")
+	if scoringMode == "" {
+		scoringMode = scoringModeFreeIPs
+	}
+	nodeMaxPods, _ = strconv.Atoi(getenv("NODE_MAX_PODS"))
+	nodeIPOverhead, _ = strconv.Atoi(getenv("NODE_IP_OVERHEAD"))
+	observeNodeIPs, _ = strconv.ParseBool(getenv("OBSERVED_NODE_IPS"))
+	customNetworking, _ = strconv.ParseBool(getenv("CUSTOM_NETWORKING"))
+	eniConfigMappingValue = getenv("ENI_CONFIG_MAPPING")
+	eniConfigMapping, _ = parseENIConfigMapping(eniConfigMappingValue)
+	subnetWeightTag = getenv("SUBNET_WEIGHT_TAG")
+	if subnetWeightTag == "" {
+		subnetWeightTag = "ca-autoconfig/weight"
+	}
+	subnetExcludeTagsValue = getenv("SUBNET_EXCLUDE_TAGS")
+	subnetExcludeTags, _ = parseTagSelector("SUBNET_EXCLUDE_TAGS", subnetExcludeTagsValue)
+	azAllow = splitList(getenv("AZ_ALLOW"))
+	azDeny = splitList(getenv("AZ_DENY"))
+	subtractPendingInstances, _ = strconv.ParseBool(getenv("SUBTRACT_PENDING_INSTANCES"))
+	subnetAggregation = getenv("SUBNET_AGGREGATION")
+	if subnetAggregation == "" {
+		subnetAggregation = subnetAggregationSum
+	}
+	freeIPsAsPercent, _ = strconv.ParseBool(getenv("FREE_IPS_AS_PERCENT"))
+	cloudWatchPublish, _ = strconv.ParseBool(getenv("CLOUDWATCH_METRICS"))
+	cloudWatchNamespace = getenv("CLOUDWATCH_NAMESPACE")
+	if cloudWatchNamespace == "" {
+		cloudWatchNamespace = "CAAutoconfig"
+	}
+	cloudWatchExtraDimensions = getenv("CLOUDWATCH_DIMENSIONS")
+	subnetLowIPThreshold, _ = strconv.Atoi(getenv("SUBNET_LOW_IP_THRESHOLD"))
+	sharedSubnetRoleARNs = splitList(getenv("SHARED_SUBNET_ROLE_ARNS"))
+	edgeSubnets = getenv("EDGE_SUBNETS")
+	exhaustionHorizon = parseDurationEnv(getenv("EXHAUSTION_HORIZON"), 0)
+	exhaustionWindow = parseDurationEnv(getenv("EXHAUSTION_WINDOW"), time.Hour)
+	smoothingRuns, _ = strconv.Atoi(getenv("SMOOTHING_RUNS"))
+	stepSize, _ = strconv.Atoi(getenv("STEP_SIZE"))
+	scoringWeights = getenv("SCORING_WEIGHTS")
+	spotAdvisorURL = getenv("SPOT_ADVISOR_URL")
+	if spotAdvisorURL == "" {
+		spotAdvisorURL = "https://spot-bid-advisor.s3.amazonaws.com/spot-advisor-data.json"
+	}
+	spotInterruptionThreshold, _ = strconv.Atoi(getenv("SPOT_INTERRUPTION_THRESHOLD"))
+	priorityNormalization = getenv("PRIORITY_NORMALIZATION")
+	if priorityNormalization == "" {
+		priorityNormalization = priorityNormalizationNone
+	}
+	priorityBandMax, _ = strconv.Atoi(getenv("PRIORITY_BAND_MAX"))
+	if priorityBandMax == 0 {
+		priorityBandMax = 100
+	}
+	hysteresisValue = getenv("HYSTERESIS")
+	minFreeIPs, _ = strconv.Atoi(getenv("MIN_FREE_IPS"))
+	minFreeIPsAction = getenv("MIN_FREE_IPS_ACTION")
+	if minFreeIPsAction == "" {
+		minFreeIPsAction = minFreeIPsDemote
+	}
+	catchAllPriority = 1
+	if value, err := strconv.Atoi(getenv("CATCH_ALL_PRIORITY")); err == nil {
+		catchAllPriority = value
+	}
+	catchAllPattern = getenv("CATCH_ALL_PATTERN")
+	if catchAllPattern == "" {
+		catchAllPattern = ".*"
+	}
+	staticEntriesValue = getenv("STATIC_ENTRIES")
+	asgExcludesValue = getenv("ASG_EXCLUDES")
+	asgExcludes, _ = parseASGExcludes(asgExcludesValue)
+	asgTagSelectorValue = getenv("ASG_TAG_SELECTOR")
+	asgTagSelector, _ = parseTagSelector("ASG_TAG_SELECTOR", asgTagSelectorValue)
+	caAutoDiscovery, _ = strconv.ParseBool(getenv("CA_AUTO_DISCOVERY"))
+	eksNodegroups, _ = strconv.ParseBool(getenv("EKS_NODEGROUPS"))
+	nodegroupContains = splitList(getenv("NODEGROUP_CONTAINS"))
+	nodegroupLabelSelectorValue = getenv("NODEGROUP_LABEL_SELECTOR")
+	nodegroupLabelSelector, _ = parseTagSelector("NODEGROUP_LABEL_SELECTOR", nodegroupLabelSelectorValue)
+	rulesSource = getenv("RULES_SOURCE")
+	rulesSHA256 = getenv("RULES_SHA256")
+	rulesSHA256Source = getenv("RULES_SHA256_SOURCE")
+	rulesRefreshMinutes, _ := strconv.Atoi(getenv("RULES_REFRESH_MINUTES"))
+	rulesRefresh = time.Duration(rulesRefreshMinutes) * time.Minute
+}
+
+// validateConfig rejects setting combinations that can't work
+func validateConfig() error {
+	if setRegion == "" {
+		return fmt.Errorf("REGION is not set and couldn't be detected from AWS_REGION, AWS_DEFAULT_REGION or the instance metadata")
+	}
+	if manageCATags && clusterName == "" {
+		return fmt.Errorf("CLUSTER_NAME is required when MANAGE_CA_TAGS is enabled")
+	}
+	if _, err := compileMatchRegex("ASG_MATCH_REGEX", asgMatchPattern); err != nil {
+		return err
+	}
+	if _, err := compileMatchRegex("LT_MATCH_REGEX", ltMatchPattern); err != nil {
+		return err
+	}
+	if _, err := parseASGExcludes(asgExcludesValue); err != nil {
+		return err
+	}
+	if _, err := parseTagSelector("SUBNET_EXCLUDE_TAGS", subnetExcludeTagsValue); err != nil {
+		return err
+	}
+	if _, err := parseTagSelector("ASG_TAG_SELECTOR", asgTagSelectorValue); err != nil {
+		return err
+	}
+	if (externalMetricsCert == "") != (externalMetricsKey == "") {
+		return fmt.Errorf("EXTERNAL_METRICS_TLS_CERT and EXTERNAL_METRICS_TLS_KEY must be set together")
+	}
+	if caAutoDiscovery && clusterName == "" {
+		return fmt.Errorf("CLUSTER_NAME is required when CA_AUTO_DISCOVERY is enabled")
+	}
+	if caAutoDiscovery && manageCATags {
+		return fmt.Errorf("CA_AUTO_DISCOVERY and MANAGE_CA_TAGS can't be enabled together")
+	}
+	if eksNodegroups && clusterName == "" {
+		return fmt.Errorf("CLUSTER_NAME is required when EKS_NODEGROUPS is enabled")
+	}
+	if _, err := parseTagSelector("NODEGROUP_LABEL_SELECTOR", nodegroupLabelSelectorValue); err != nil {
+		return err
+	}
+	if !validGitOpsMode() {
+		return fmt.Errorf("unsupported GITOPS_MODE %q, expected argocd or flux", gitOpsMode)
+	}
+	if scoringExec != "" && len(strings.Fields(scoringExec)) == 0 {
+		return fmt.Errorf("invalid SCORING_EXEC: no command given")
+	}
+	if shadowScoring != "" {
+		if _, err := parseScorer(shadowScoring); err != nil {
+			return fmt.Errorf("invalid SHADOW_SCORING: %v", err)
+		}
+	}
+	if err := validateMigration(); err != nil {
+		return err
+	}
+	if !validSharedSubnetAccounting() {
+		return fmt.Errorf("unsupported SHARED_SUBNET_ACCOUNTING %q, expected split or full", sharedSubnetAccounting)
+	}
+	if !validOutputFormat() {
+		return fmt.Errorf("unsupported OUTPUT_FORMAT %q, expected configmap, terraform or tfvars", outputFormat)
+	}
+	if !validEdgeSubnets() {
+		return fmt.Errorf("unsupported EDGE_SUBNETS %q, expected demote or tier", edgeSubnets)
+	}
+	if !validSubnetAggregation() {
+		return fmt.Errorf("unsupported SUBNET_AGGREGATION %q, expected sum, min, avg or max", subnetAggregation)
+	}
+	if !validGPUNodeGroups() {
+		return fmt.Errorf("unsupported GPU_NODE_GROUPS %q, expected include, exclude, only or demote", gpuNodeGroups)
+	}
+	if !validInstanceRefreshAction() {
+		return fmt.Errorf("unsupported INSTANCE_REFRESH_ACTION %q, expected demote or exclude", instanceRefreshAction)
+	}
+	if ipFamily != ipFamilyIPv4 && ipFamily != ipFamilyIPv6 {
+		return fmt.Errorf("unsupported IP_FAMILY %q, expected ipv4 or ipv6", ipFamily)
+	}
+	if _, ok := scoringStrategies[scoringMode]; !ok {
+		return fmt.Errorf("unsupported SCORING_MODE %q, expected one of %s", scoringMode, strings.Join(scoringStrategyNames(), ", "))
+	}
+	if _, ok := pricingRegion(); scoringMode == scoringModeCheapestFirst && !ok {
+		return fmt.Errorf("SCORING_MODE %s needs the Price List API, which isn't available in the %s partition", scoringModeCheapestFirst, awsPartition())
+	}
+	if _, err := parseENIConfigMapping(eniConfigMappingValue); err != nil {
+		return err
+	}
+	if _, err := parseScoringWeights(scoringWeights); err != nil {
+		return err
+	}
+	if _, err := parseHysteresis(hysteresisValue); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(catchAllPattern); err != nil {
+		return fmt.Errorf("invalid CATCH_ALL_PATTERN %q: %v", catchAllPattern, err)
+	}
+	if _, err := parseStaticEntries(staticEntriesValue); err != nil {
+		return err
+	}
+	if !validMinFreeIPsAction() {
+		return fmt.Errorf("unsupported MIN_FREE_IPS_ACTION %q, expected demote or exclude", minFreeIPsAction)
+	}
+	if !validPriorityNormalization() {
+		return fmt.Errorf("unsupported PRIORITY_NORMALIZATION %q, expected none or bands", priorityNormalization)
+	}
+	if priorityNormalization == priorityNormalizationBands && priorityBandMax <= demotedScore+1 {
+		return fmt.Errorf("PRIORITY_BAND_MAX %d must be above DEMOTED_SCORE+1 (%d)", priorityBandMax, demotedScore+1)
+	}
+	if !validPartition() {
+		return fmt.Errorf("unsupported AWS_PARTITION %q, expected aws, aws-cn or aws-us-gov", partitionOverride)
+	}
+	if stsRegionalEndpoints != "" && stsRegionalEndpoints != "regional" && stsRegionalEndpoints != "legacy" {
+		return fmt.Errorf("unsupported STS_REGIONAL_ENDPOINTS %q, expected regional or legacy", stsRegionalEndpoints)
+	}
+	if !validCollation() {
+		return fmt.Errorf("unsupported NAME_COLLATION %q, expected lexical, natural or caseless", nameCollation)
+	}
+	if scheduleExpr != "" {
+		if _, err := parseCron(scheduleExpr, scheduleTimezone); err != nil {
+			return fmt.Errorf("invalid SCHEDULE: %v", err)
+		}
+	}
+	if err := validateRulesSource(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseDurationEnv parses a duration setting, falling back to the default
+// when unset or invalid
+func parseDurationEnv(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("Invalid duration %q, using %s\n", value, fallback)
+		return fallback
+	}
+	return parsed
+}
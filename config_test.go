@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestSharedSubnetAccountingDefaultsToFull(t *testing.T) {
+	defer func(accounting string) { sharedSubnetAccounting = accounting }(sharedSubnetAccounting)
+
+	loadConfig(func(string) string { return "" })
+	if sharedSubnetAccounting != sharedSubnetsFull {
+		t.Errorf("sharedSubnetAccounting = %q, want %q so existing deployments keep counting shared subnets in full", sharedSubnetAccounting, sharedSubnetsFull)
+	}
+}
+
+func TestValidateConfigScoringExec(t *testing.T) {
+	defer loadConfig(os.Getenv)
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "command", command: "/usr/local/bin/score --json"},
+		{name: "blank", command: "   ", wantErr: true},
+		{name: "tabs", command: "\t\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadConfig(func(key string) string {
+				switch key {
+				case "REGION":
+					return "us-east-1"
+				case "SCORING_EXEC":
+					return tt.command
+				}
+				return ""
+			})
+			if err := validateConfig(); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecScoresRejectsBlankCommand(t *testing.T) {
+	if _, err := execScores(context.Background(), " ", nil); err == nil {
+		t.Error("execScores() with a blank command returned no error")
+	}
+}
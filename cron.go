@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// embedded so SCHEDULE_TIMEZONE works on images without zoneinfo
+	_ "time/tzdata"
+)
+
+// cronSchedule is a parsed standard 5-field cron expression
+type cronSchedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// whether day of month and day of week were restricted, cron matches
+	// either of them when both are
+	daysRestricted     bool
+	weekdaysRestricted bool
+	location           *time.Location
+}
+
+// parseCronField expands a cron field (*, lists, ranges and steps) into the
+// set of values it matches
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			low, err1 = strconv.Atoi(bounds[0])
+			high, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			low = value
+			if step == 1 {
+				high = value
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// parseCron parses "minute hour day-of-month month day-of-week" evaluated in
+// the given timezone
+func parseCron(expression, timezone string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q", expression)
+	}
+
+	location := time.Local
+	if timezone != "" {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	schedule := &cronSchedule{
+		location:           location,
+		daysRestricted:     fields[2] != "*",
+		weekdaysRestricted: fields[4] != "*",
+	}
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// both 0 and 7 mean Sunday
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+	return schedule, nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minutes[t.Minute()] || !c.hours[t.Hour()] || !c.months[int(t.Month())] {
+		return false
+	}
+
+	dayMatch := c.days[t.Day()]
+	weekdayMatch := c.weekdays[int(t.Weekday())]
+	if c.daysRestricted && c.weekdaysRestricted {
+		return dayMatch || weekdayMatch
+	}
+	return dayMatch && weekdayMatch
+}
+
+// next returns the first matching minute strictly after t
+func (c *cronSchedule) next(t time.Time) time.Time {
+	candidate := t.In(c.location).Truncate(time.Minute).Add(time.Minute)
+	// an expression such as "0 0 30 2 *" never matches, give up after
+	// looking a year ahead
+	limit := candidate.AddDate(1, 0, 1)
+	for candidate.Before(limit) {
+		if c.matches(candidate) {
+			return candidate
+		}
+		candidate = candidate.Add(time.Minute)
+	}
+	return time.Time{}
+}
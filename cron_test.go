@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		after      string
+		want       string
+		wantErr    bool
+	}{
+		{name: "every minute", expression: "* * * * *", after: "2026-03-02T10:15:30Z", want: "2026-03-02T10:16:00Z"},
+		{name: "steps", expression: "*/20 * * * *", after: "2026-03-02T10:15:00Z", want: "2026-03-02T10:20:00Z"},
+		{name: "ranges and lists", expression: "0 8-10,18 * * *", after: "2026-03-02T10:30:00Z", want: "2026-03-02T18:00:00Z"},
+		{name: "sunday as 7", expression: "0 0 * * 7", after: "2026-03-02T00:00:00Z", want: "2026-03-08T00:00:00Z"},
+		{name: "day of month or week", expression: "0 0 15 * 1", after: "2026-03-03T00:00:00Z", want: "2026-03-09T00:00:00Z"},
+		{name: "never matches", expression: "0 0 30 2 *", after: "2026-03-02T00:00:00Z", want: ""},
+		{name: "too few fields", expression: "* * * *", wantErr: true},
+		{name: "out of range", expression: "60 * * * *", wantErr: true},
+		{name: "reversed range", expression: "* 10-8 * * *", wantErr: true},
+		{name: "zero step", expression: "*/0 * * * *", wantErr: true},
+		{name: "not a number", expression: "* * * jan *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.expression, "UTC")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCron(%q) error = %v, wantErr %v", tt.expression, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			after, _ := time.Parse(time.RFC3339, tt.after)
+			got := schedule.next(after)
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("next(%s) = %s, want none", tt.after, got)
+				}
+				return
+			}
+			want, _ := time.Parse(time.RFC3339, tt.want)
+			if !got.Equal(want) {
+				t.Errorf("next(%s) = %s, want %s", tt.after, got, want)
+			}
+		})
+	}
+}
+
+func TestParseCronTimezone(t *testing.T) {
+	schedule, err := parseCron("0 9 * * *", "Europe/Madrid")
+	if err != nil {
+		t.Fatalf("parseCron() error: %v", err)
+	}
+	after, _ := time.Parse(time.RFC3339, "2026-01-05T00:00:00Z")
+	want, _ := time.Parse(time.RFC3339, "2026-01-05T08:00:00Z")
+	if got := schedule.next(after); !got.Equal(want) {
+		t.Errorf("next() = %s, want %s", got, want)
+	}
+
+	if _, err := parseCron("0 9 * * *", "Nowhere/Special"); err == nil {
+		t.Error("parseCron() accepted an unknown timezone")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// scores of the previous reconcile that wrote its priorities, nil until the
+// first one does
+var previousScores map[string]int
+
+// rankASGs returns the ASG names from highest to lowest score
+func rankASGs(scores map[string]int) []string {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if scores[names[i]] != scores[names[j]] {
+			return scores[names[i]] > scores[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// logScoreChanges logs what changed since the previous reconcile: ASGs added
+// or removed, scores that moved and ranking changes. The full per-ASG dump is
+// only printed in debug mode. previousScores only moves on in commitScores,
+// so a change whose write is deferred or fails is reported again next cycle
+func logScoreChanges(scores map[string]int) {
+	ranking := rankASGs(scores)
+
+	if debug {
+		for _, name := range ranking {
+			fmt.Printf("DEBUG: %s scored %d\n", name, scores[name])
+		}
+	}
+
+	if previousScores == nil {
+		fmt.Printf("Initial priorities computed for %d ASG(s)\n", len(scores))
+		return
+	}
+
+	changes := 0
+	for _, name := range ranking {
+		previous, ok := previousScores[name]
+		switch {
+		case !ok:
+			fmt.Printf("ASG added: %s (score %d)\n", name, scores[name])
+			changes++
+		case previous != scores[name]:
+			fmt.Printf("ASG score moved: %s %d -> %d\n", name, previous, scores[name])
+			changes++
+		}
+	}
+	for _, name := range rankASGs(previousScores) {
+		if _, ok := scores[name]; !ok {
+			fmt.Printf("ASG removed: %s\n", name)
+			changes++
+		}
+	}
+
+	previousRanking := rankASGs(previousScores)
+	if strings.Join(previousRanking, ",") != strings.Join(ranking, ",") {
+		fmt.Printf("ASG order changed: %s\n", strings.Join(ranking, ", "))
+		changes++
+	}
+
+	if changes == 0 {
+		fmt.Println("No priority changes since previous run")
+	}
+}
+
+// commitScores records the scores of a plan once its priorities are written
+func commitScores(scores map[string]int) {
+	previousScores = scores
+	if rolloutMaxChanges > 0 {
+		rolloutScores = scores
+	}
+}
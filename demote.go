@@ -0,0 +1,14 @@
+package main
+
+import "fmt"
+
+// demoteASG lowers the ASG's score to DEMOTED_SCORE so CA only tries it once
+// the healthy groups have been tried, recording why in the status
+func demoteASG(scores map[string]int, status statusReport, name, reason string) {
+	if scores[name] <= demotedScore {
+		return
+	}
+	fmt.Printf("Demoting ASG %s: %s (score %d -> %d)\n", name, reason, scores[name], demotedScore)
+	scores[name] = demotedScore
+	status.add("demoted", name+": "+reason)
+}
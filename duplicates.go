@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// qualifyDuplicateNames namespaces the ASGs that share their name with an ASG
+// of another account or region by their account and region, so each keeps a
+// score of its own
+func qualifyDuplicateNames(asgs []*asgInfo) {
+	count := make(map[string]int, len(asgs))
+	for _, asg := range asgs {
+		count[asg.Name]++
+	}
+	for _, asg := range asgs {
+		if count[asg.Name] > 1 {
+			asg.Qualifier = asg.api().qualifier()
+		}
+	}
+}
+
+// key identifies the ASG in scores, metrics and logs: its name, qualified by
+// its account and region when that name isn't unique
+func (a *asgInfo) key() string {
+	if a.Qualifier == "" {
+		return a.Name
+	}
+	return a.Qualifier + "/" + a.Name
+}
+
+// listedDuplicateNames returns the key of the ASG whose priority each name
+// is listed at. CA only matches ASG names, so a name shared across accounts
+// or regions can only go in the document once: at the priority of the ASG in
+// REGION of the controller's account, otherwise of the best scored one
+func listedDuplicateNames(matched []*asgInfo, scores map[string]int, status statusReport) map[string]string {
+	byName := make(map[string][]*asgInfo, len(matched))
+	for _, asg := range matched {
+		byName[asg.Name] = append(byName[asg.Name], asg)
+	}
+
+	listed := make(map[string]string, len(byName))
+	for name, asgs := range byName {
+		best := asgs[0]
+		for _, asg := range asgs[1:] {
+			switch {
+			case best.api() == homeClients:
+			case asg.api() == homeClients,
+				scores[asg.key()] > scores[best.key()],
+				scores[asg.key()] == scores[best.key()] && asg.key() < best.key():
+				best = asg
+			}
+		}
+		listed[name] = best.key()
+
+		if len(asgs) > 1 {
+			keys := make([]string, 0, len(asgs))
+			for _, asg := range asgs {
+				keys = append(keys, asg.key())
+			}
+			sort.Strings(keys)
+			fmt.Printf("ASG name %s is used by %s, listed at the priority of %s\n", name, strings.Join(keys, ", "), best.key())
+			status.add("duplicateNames", name+": listed at the priority of "+best.key())
+		}
+	}
+	return listed
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// EDGE_SUBNETS values, what to do with the ASGs using subnets in Local Zones,
+// Wavelength Zones or on Outposts, whose capacity and latency differ from
+// those of regular availability zones
+const (
+	edgeSubnetsDemote = "demote"
+	edgeSubnetsTier   = "tier"
+)
+
+// validEdgeSubnets reports whether EDGE_SUBNETS is supported
+func validEdgeSubnets() bool {
+	return edgeSubnets == "" || edgeSubnets == edgeSubnetsDemote || edgeSubnets == edgeSubnetsTier
+}
+
+// zoneTypes returns the type of every zone of the region of the clients,
+// availability-zone, local-zone or wavelength-zone, caching them by region
+// for the run
+func zoneTypes(clients *awsClients, cache map[string]map[string]string) (map[string]string, error) {
+	if types, ok := cache[clients.String()]; ok {
+		return types, nil
+	}
+	output, err := clients.ec2.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		AllAvailabilityZones: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(output.AvailabilityZones))
+	for _, zone := range output.AvailabilityZones {
+		types[aws.StringValue(zone.ZoneName)] = aws.StringValue(zone.ZoneType)
+	}
+	cache[clients.String()] = types
+	return types, nil
+}
+
+// asgEdgeLocations returns the Local Zones, Wavelength Zones and Outposts the
+// described subnets of the ASG are in
+func asgEdgeLocations(asg *asgInfo, subnets *subnetInventory, cache map[string]map[string]string) ([]string, error) {
+	types, err := zoneTypes(asg.api(), cache)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	for _, subnetID := range asg.subnetIDs {
+		subnet, ok := subnets.subnets[subnetID]
+		if !ok {
+			continue
+		}
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		switch {
+		case aws.StringValue(subnet.OutpostArn) != "":
+			found["outpost "+aws.StringValue(subnet.OutpostArn)] = true
+		case types[zone] != "" && types[zone] != "availability-zone":
+			found[types[zone]+" "+zone] = true
+		}
+	}
+	locations := make([]string, 0, len(found))
+	for location := range found {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	return locations, nil
+}
+
+// applyEdgeSubnets deprioritizes the ASGs using edge subnets. demote lowers
+// them to DEMOTED_SCORE, tier moves them to a tier of their own below every
+// other ASG, where they keep their order
+func applyEdgeSubnets(matched []*asgInfo, subnets *subnetInventory, scores map[string]int, status statusReport) {
+	cache := make(map[string]map[string]string)
+	edge := make(map[string]string)
+	for _, asg := range matched {
+		locations, err := asgEdgeLocations(asg, subnets, cache)
+		if err != nil {
+			fmt.Printf("Error retrieving the zones of %s: %v\n", asg.api(), err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+			continue
+		}
+		if len(locations) > 0 {
+			edge[asg.key()] = "edge subnets (" + strings.Join(locations, ", ") + ")"
+		}
+	}
+	if len(edge) == 0 {
+		return
+	}
+
+	if edgeSubnets == edgeSubnetsDemote {
+		for _, asg := range matched {
+			if reason, ok := edge[asg.key()]; ok {
+				demoteASG(scores, status, asg.key(), reason)
+			}
+		}
+		return
+	}
+
+	// the edge tier sits between DEMOTED_SCORE and the lowest regular score
+	floor := -1
+	highest := 0
+	for name, score := range scores {
+		if _, ok := edge[name]; ok {
+			if score > highest {
+				highest = score
+			}
+		} else if score > demotedScore && (floor < 0 || score < floor) {
+			floor = score
+		}
+	}
+	for _, asg := range matched {
+		name := asg.key()
+		reason, ok := edge[name]
+		if !ok {
+			continue
+		}
+		score := scores[name]
+		if score <= demotedScore {
+			continue
+		}
+		tiered := score
+		if floor >= 0 {
+			room := floor - demotedScore - 1
+			tiered = demotedScore + 1
+			if room > 1 && highest > 0 {
+				tiered += score * (room - 1) / highest
+			}
+		}
+		if tiered != score {
+			fmt.Printf("Moving ASG %s to the edge tier: %s (score %d -> %d)\n", name, reason, score, tiered)
+			scores[name] = tiered
+		}
+		status.add("edgeTier", name+": "+reason)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// serviceEndpointVars maps the endpoint IDs of the services we call to the
+// suffix of their AWS_ENDPOINT_URL_<SERVICE> override
+var serviceEndpointVars = map[string]string{
+	"api.pricing":   "PRICING",
+	"autoscaling":   "AUTO_SCALING",
+	"ec2":           "EC2",
+	"eks":           "EKS",
+	"iam":           "IAM",
+	"monitoring":    "CLOUDWATCH",
+	"organizations": "ORGANIZATIONS",
+	"s3":            "S3",
+	"servicequotas": "SERVICE_QUOTAS",
+	"sts":           "STS",
+}
+
+// loadEndpointOverrides reads AWS_ENDPOINT_URL_<SERVICE> for every service
+// we call, falling back to AWS_ENDPOINT_URL
+func loadEndpointOverrides(getenv func(string) string) map[string]string {
+	overrides := make(map[string]string)
+	for service, suffix := range serviceEndpointVars {
+		if url := getenv("AWS_ENDPOINT_URL_" + suffix); url != "" {
+			overrides[service] = url
+		} else if url := getenv("AWS_ENDPOINT_URL"); url != "" {
+			overrides[service] = url
+		}
+	}
+	return overrides
+}
+
+// endpointResolver sends the services with an endpoint override, such as
+// LocalStack or a VPC interface endpoint, to it and every other one, the
+// instance metadata included, to the SDK's own endpoint
+func endpointResolver() endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		if url, ok := endpointOverrides[service]; ok {
+			return endpoints.ResolvedEndpoint{URL: url, SigningRegion: region}, nil
+		}
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	})
+}
+
+// awsPartition returns the partition ARNs are built in: AWS_PARTITION, or
+// the partition of REGION, e.g. aws-us-gov for us-gov-west-1 or aws-cn for
+// cn-north-1
+func awsPartition() string {
+	if partitionOverride != "" {
+		return partitionOverride
+	}
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), setRegion); ok {
+		return partition.ID()
+	}
+	return endpoints.AwsPartitionID
+}
+
+// validPartition reports whether AWS_PARTITION is a known partition
+func validPartition() bool {
+	switch partitionOverride {
+	case "", endpoints.AwsPartitionID, endpoints.AwsCnPartitionID, endpoints.AwsUsGovPartitionID:
+		return true
+	}
+	return false
+}
+
+// stsRegionalEndpoint returns how STS is reached: the regional endpoint
+// unless STS_REGIONAL_ENDPOINTS is legacy, since the global one doesn't
+// exist outside the aws partition
+func stsRegionalEndpoint() endpoints.STSRegionalEndpoint {
+	if stsRegionalEndpoints == "legacy" {
+		return endpoints.LegacySTSEndpoint
+	}
+	return endpoints.RegionalSTSEndpoint
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// eniConfigResource is the VPC CNI custom networking resource
+var eniConfigResource = schema.GroupVersionResource{
+	Group:    "crd.k8s.amazonaws.com",
+	Version:  "v1alpha1",
+	Resource: "eniconfigs",
+}
+
+// parseENIConfigMapping parses ENI_CONFIG_MAPPING, a comma separated list of
+// <node subnet or availability zone>=<pod subnet>
+func parseENIConfigMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, entry := range splitList(value) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid ENI_CONFIG_MAPPING entry %q, expected <subnet or zone>=<pod subnet>", entry)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+// podSubnetMapping maps node subnet IDs and availability zones to the subnet
+// the VPC CNI gives their pods addresses from
+type podSubnetMapping map[string]string
+
+// loadPodSubnets reads the ENIConfigs of the cluster, named after the zone
+// they apply to as the VPC CNI expects by default, overlaid with
+// ENI_CONFIG_MAPPING
+func loadPodSubnets() (podSubnetMapping, error) {
+	mapping := make(podSubnetMapping)
+
+	config, _, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	eniConfigs, err := dynamicClient.Resource(eniConfigResource).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing ENIConfigs: %v", err)
+	}
+	for _, eniConfig := range eniConfigs.Items {
+		if subnetID, _, _ := unstructured.NestedString(eniConfig.Object, "spec", "subnet"); subnetID != "" {
+			mapping[eniConfig.GetName()] = subnetID
+		}
+	}
+
+	for key, subnetID := range eniConfigMapping {
+		mapping[key] = subnetID
+	}
+	if debug {
+		fmt.Printf("DEBUG: pod subnets: %v\n", map[string]string(mapping))
+	}
+	return mapping, nil
+}
+
+// podSubnet returns the subnet the pods of nodes launched in the node subnet
+// take their addresses from: the one mapped to the subnet itself, else to its
+// zone, or the node subnet when custom networking doesn't apply to it
+func (m podSubnetMapping) podSubnet(subnet *ec2.Subnet) string {
+	if podSubnetID, ok := m[aws.StringValue(subnet.SubnetId)]; ok {
+		return podSubnetID
+	}
+	if podSubnetID, ok := m[aws.StringValue(subnet.AvailabilityZone)]; ok {
+		return podSubnetID
+	}
+	return aws.StringValue(subnet.SubnetId)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const eventComponent = "ca-autoconfig"
+
+// recordEvent emits a Kubernetes event attached to the priority expander
+// configmap so findings show up in kubectl describe
+func recordEvent(clientset kubernetes.Interface, eventType, reason, message string) {
+	now := metav1.Now()
+	_, err := clientset.CoreV1().Events(caNamespace).Create(context.Background(), &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: caPriorityExpander + ".",
+			Namespace:    caNamespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Namespace:  caNamespace,
+			Name:       caPriorityExpander,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		fmt.Printf("Error recording event %s: %v\n", reason, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "errors"
+
+// Process exit codes, stable so wrappers and CronJob monitoring can react
+// differently to each failure class. Documented in README.md
+const (
+	exitOK                = 0
+	exitGenericError      = 1
+	exitConfigError       = 2
+	exitAWSDiscoveryError = 3
+	exitKubernetesError   = 4
+	exitValidationError   = 5
+)
+
+// runError is a failure tagged with the exit code of its class
+type runError struct {
+	code int
+	err  error
+}
+
+func (e *runError) Error() string {
+	return e.err.Error()
+}
+
+func newRunError(code int, err error) error {
+	return &runError{code: code, err: err}
+}
+
+// exitCode returns the exit code for the given error
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var re *runError
+	if errors.As(err, &re) {
+		return re.code
+	}
+	return exitGenericError
+}
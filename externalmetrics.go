@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	externalMetricsGroupVersion = "external.metrics.k8s.io/v1beta1"
+
+	// longest Kubernetes label value
+	labelValueMaxLength = 63
+)
+
+// metrics exposed through the external metrics API
+var externalMetricNames = []string{metricASGFreeIPs, metricASGPriority}
+
+type externalMetricValue struct {
+	MetricName   string            `json:"metricName"`
+	MetricLabels map[string]string `json:"metricLabels"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Value        string            `json:"value"`
+}
+
+type externalMetricValueList struct {
+	Kind       string                `json:"kind"`
+	APIVersion string                `json:"apiVersion"`
+	Metadata   map[string]string     `json:"metadata"`
+	Items      []externalMetricValue `json:"items"`
+}
+
+type apiResource struct {
+	Name         string   `json:"name"`
+	SingularName string   `json:"singularName"`
+	Namespaced   bool     `json:"namespaced"`
+	Kind         string   `json:"kind"`
+	Verbs        []string `json:"verbs"`
+}
+
+type apiResourceList struct {
+	Kind         string        `json:"kind"`
+	APIVersion   string        `json:"apiVersion"`
+	GroupVersion string        `json:"groupVersion"`
+	Resources    []apiResource `json:"resources"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// externalMetricsHandler serves the subset of the external.metrics.k8s.io API
+// the HPA controller uses: discovery and per-metric value lists
+func externalMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizedProxy(r) {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+
+	prefix := "/apis/" + externalMetricsGroupVersion
+	path := strings.TrimSuffix(r.URL.Path, "/")
+
+	if path == prefix {
+		resources := make([]apiResource, 0, len(externalMetricNames))
+		for _, name := range externalMetricNames {
+			resources = append(resources, apiResource{
+				Name:       name,
+				Namespaced: true,
+				Kind:       "ExternalMetricValueList",
+				Verbs:      []string{"get"},
+			})
+		}
+		writeJSON(w, http.StatusOK, apiResourceList{
+			Kind:         "APIResourceList",
+			APIVersion:   "v1",
+			GroupVersion: externalMetricsGroupVersion,
+			Resources:    resources,
+		})
+		return
+	}
+
+	// /apis/external.metrics.k8s.io/v1beta1/namespaces/<namespace>/<metric>
+	parts := strings.Split(strings.TrimPrefix(path, prefix+"/"), "/")
+	if len(parts) != 3 || parts[0] != "namespaces" {
+		http.NotFound(w, r)
+		return
+	}
+
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid labelSelector: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	list := externalMetricValueList{
+		Kind:       "ExternalMetricValueList",
+		APIVersion: externalMetricsGroupVersion,
+		Metadata:   map[string]string{},
+		Items:      []externalMetricValue{},
+	}
+	for _, sample := range metrics.samples(parts[2]) {
+		metricLabels := externalMetricLabels(sample.labels)
+		if !selector.Matches(labels.Set(metricLabels)) {
+			continue
+		}
+		list.Items = append(list.Items, externalMetricValue{
+			MetricName:   sample.name,
+			MetricLabels: metricLabels,
+			Timestamp:    time.Now().UTC(),
+			Value:        fmt.Sprintf("%d", int64(sample.value)),
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// externalMetricLabels returns the labels of a sample as Kubernetes label
+// values, which can't hold the "/" of qualified ASG names
+// (123456789012/eu-west-1/workers is served as 123456789012.eu-west-1.workers)
+// nor be longer than 63 characters. Longer values are cut short and end with
+// a hash of the full value, so they stay distinct
+func externalMetricLabels(sampleLabels map[string]string) map[string]string {
+	metricLabels := make(map[string]string, len(sampleLabels))
+	for name, value := range sampleLabels {
+		value = strings.ReplaceAll(value, "/", ".")
+		if len(value) > labelValueMaxLength {
+			sum := sha256.Sum256([]byte(value))
+			value = value[:labelValueMaxLength-9] + "-" + hex.EncodeToString(sum[:4])
+		}
+		metricLabels[name] = value
+	}
+	return metricLabels
+}
+
+// authorizedProxy reports whether the request comes from the kube-apiserver,
+// which presents a client certificate signed by EXTERNAL_METRICS_CLIENT_CA
+// when proxying aggregated API calls. Any client is accepted without it
+func authorizedProxy(r *http.Request) bool {
+	if externalMetricsClientCA == "" {
+		return true
+	}
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// selfSignedCertificate generates a serving certificate for when none is
+// provided; the APIService is then expected to use insecureSkipTLSVerify
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "ca-autoconfig"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// serveExternalMetrics starts the external metrics API server
+func serveExternalMetrics() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apis/"+externalMetricsGroupVersion, externalMetricsHandler)
+	mux.HandleFunc("/apis/"+externalMetricsGroupVersion+"/", externalMetricsHandler)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	server := &http.Server{Addr: externalMetricsAddr, Handler: mux, TLSConfig: &tls.Config{}}
+
+	if externalMetricsClientCA != "" {
+		pem, err := os.ReadFile(externalMetricsClientCA)
+		if err != nil {
+			fmt.Printf("Unable to read the external metrics client CA: %v\n", err)
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fmt.Printf("No certificate found in the external metrics client CA %s\n", externalMetricsClientCA)
+			return
+		}
+		// the health checks come without a client certificate
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	} else {
+		fmt.Println("EXTERNAL_METRICS_CLIENT_CA is not set, the external metrics API answers any client: restrict it to the kube-apiserver with a NetworkPolicy")
+	}
+
+	var err error
+	if externalMetricsCert != "" && externalMetricsKey != "" {
+		err = server.ListenAndServeTLS(externalMetricsCert, externalMetricsKey)
+	} else {
+		fmt.Println("EXTERNAL_METRICS_TLS_CERT and EXTERNAL_METRICS_TLS_KEY are not set, serving the external metrics API with a self-signed certificate")
+		cert, certErr := selfSignedCertificate()
+		if certErr != nil {
+			fmt.Printf("Unable to generate external metrics certificate: %v\n", certErr)
+			return
+		}
+		server.TLSConfig.Certificates = []tls.Certificate{cert}
+		err = server.ListenAndServeTLS("", "")
+	}
+	fmt.Printf("External metrics server stopped: %v\n", err)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExternalMetricLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{name: "plain name", labels: map[string]string{"asg": "workers"}, want: map[string]string{"asg": "workers"}},
+		{name: "region qualified", labels: map[string]string{"asg": "eu-west-1/workers"}, want: map[string]string{"asg": "eu-west-1.workers"}},
+		{
+			name:   "account qualified",
+			labels: map[string]string{"asg": "123456789012/eu-west-1/workers", "profile": "default"},
+			want:   map[string]string{"asg": "123456789012.eu-west-1.workers", "profile": "default"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := externalMetricLabels(tt.labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("externalMetricLabels(%v) = %v, want %v", tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExternalMetricLabelsStayWithinTheLengthLimit(t *testing.T) {
+	long := "123456789012/eu-west-1/" + strings.Repeat("workers-", 8)
+	other := "123456789012/eu-west-1/" + strings.Repeat("workers-", 7) + "spot"
+
+	got := externalMetricLabels(map[string]string{"asg": long})["asg"]
+	if len(got) != labelValueMaxLength {
+		t.Errorf("label value %q is %d characters long, want %d", got, len(got), labelValueMaxLength)
+	}
+	if !strings.HasPrefix(got, "123456789012.eu-west-1.workers-") {
+		t.Errorf("label value %q lost the start of the name", got)
+	}
+	if got == externalMetricLabels(map[string]string{"asg": other})["asg"] {
+		t.Errorf("names sharing their first characters map to the same label value %q", got)
+	}
+}
+
+func TestExternalMetricsHandlerRequiresTheProxyCertificate(t *testing.T) {
+	defer func(ca string) { externalMetricsClientCA = ca }(externalMetricsClientCA)
+	path := "/apis/" + externalMetricsGroupVersion
+
+	tests := []struct {
+		name     string
+		clientCA string
+		tls      *tls.ConnectionState
+		want     int
+	}{
+		{name: "no client CA", want: http.StatusOK},
+		{name: "no certificate", clientCA: "/etc/front-proxy/ca.crt", tls: &tls.ConnectionState{}, want: http.StatusUnauthorized},
+		{name: "plain HTTP", clientCA: "/etc/front-proxy/ca.crt", want: http.StatusUnauthorized},
+		{
+			name:     "verified certificate",
+			clientCA: "/etc/front-proxy/ca.crt",
+			tls:      &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}},
+			want:     http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			externalMetricsClientCA = tt.clientCA
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			request.TLS = tt.tls
+			recorder := httptest.NewRecorder()
+			externalMetricsHandler(recorder, request)
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// freeIPsSample is the free IPs of a subnet at some point
+type freeIPsSample struct {
+	at      time.Time
+	freeIPs float64
+}
+
+// freeIPsHistory keeps the free IPs of every measured subnet over the last
+// EXHAUSTION_WINDOW, recorded once per run
+var freeIPsHistory = struct {
+	samples map[string][]freeIPsSample
+	// inventory of the run that recorded the last samples, so profiles
+	// sharing it don't record them twice
+	recordedBy *subnetInventory
+}{samples: make(map[string][]freeIPsSample)}
+
+// recordFreeIPs adds the free IPs measured by a run to the history, dropping
+// the samples older than EXHAUSTION_WINDOW
+func recordFreeIPs(subnets *subnetInventory, subnetSamples []metricSample, now time.Time) {
+	if freeIPsHistory.recordedBy == subnets {
+		return
+	}
+	freeIPsHistory.recordedBy = subnets
+	for _, sample := range subnetSamples {
+		subnetID := sample.labels["subnet"]
+		freeIPsHistory.samples[subnetID] = append(freeIPsHistory.samples[subnetID], freeIPsSample{at: now, freeIPs: sample.value})
+	}
+	for subnetID, samples := range freeIPsHistory.samples {
+		kept := samples[:0]
+		for _, sample := range samples {
+			if now.Sub(sample.at) <= exhaustionWindow {
+				kept = append(kept, sample)
+			}
+		}
+		if len(kept) == 0 {
+			delete(freeIPsHistory.samples, subnetID)
+		} else {
+			freeIPsHistory.samples[subnetID] = kept
+		}
+	}
+}
+
+// exhaustionForecast fits a linear trend to the samples and returns how long
+// until the subnet runs out of free IPs at that pace, or false when it isn't
+// losing any or there are fewer than three samples
+func exhaustionForecast(samples []freeIPsSample) (time.Duration, bool) {
+	if len(samples) < 3 {
+		return 0, false
+	}
+	var meanX, meanY float64
+	for _, sample := range samples {
+		meanX += sample.at.Sub(samples[0].at).Seconds()
+		meanY += sample.freeIPs
+	}
+	meanX /= float64(len(samples))
+	meanY /= float64(len(samples))
+
+	var covariance, variance float64
+	for _, sample := range samples {
+		x := sample.at.Sub(samples[0].at).Seconds() - meanX
+		covariance += x * (sample.freeIPs - meanY)
+		variance += x * x
+	}
+	if variance == 0 || covariance >= 0 {
+		return 0, false
+	}
+	slope := covariance / variance
+	latest := samples[len(samples)-1].freeIPs
+	return time.Duration(-latest / slope * float64(time.Second)), true
+}
+
+// applyExhaustionForecast demotes the ASGs using a subnet that the trend of
+// its free IPs over EXHAUSTION_WINDOW runs out of within EXHAUSTION_HORIZON,
+// raising an event for every such subnet and exporting every forecast
+func applyExhaustionForecast(clientset kubernetes.Interface, matched []*asgInfo, subnets *subnetInventory, subnetSamples []metricSample, scores map[string]int, status statusReport) {
+	recordFreeIPs(subnets, subnetSamples, time.Now())
+
+	var forecastSamples []metricSample
+	exhausting := make(map[string]bool)
+	for _, sample := range subnetSamples {
+		subnetID := sample.labels["subnet"]
+		remaining, ok := exhaustionForecast(freeIPsHistory.samples[subnetID])
+		if !ok {
+			continue
+		}
+		forecastSamples = append(forecastSamples, metricSample{
+			labels: map[string]string{"subnet": subnetID},
+			value:  remaining.Seconds(),
+		})
+		if remaining > exhaustionHorizon {
+			continue
+		}
+		exhausting[subnetID] = true
+		message := fmt.Sprintf("Subnet %s is forecast to run out of free IPs in %s", subnetID, remaining.Round(time.Minute))
+		fmt.Println(message)
+		recordEvent(clientset, v1.EventTypeWarning, "SubnetExhaustionForecast", message)
+	}
+	metrics.replaceGauge(metricSubnetExhaustionSeconds, profileLabels(), forecastSamples)
+
+	for _, asg := range matched {
+		var subnetIDs []string
+		for subnetID := range asg.Subnets {
+			if exhausting[subnetID] {
+				subnetIDs = append(subnetIDs, subnetID)
+			}
+		}
+		if len(subnetIDs) > 0 {
+			sort.Strings(subnetIDs)
+			demoteASG(scores, status, asg.key(), fmt.Sprintf("subnets %v forecast to run out within %s", subnetIDs, exhaustionHorizon))
+		}
+	}
+}
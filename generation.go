@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// asgInstanceTypes returns every instance type the ASG can launch: the
+// launch template's own type and the MixedInstancesPolicy overrides, the
+// types of the override launch templates included
+func asgInstanceTypes(asg *asgInfo) ([]string, error) {
+	types := append([]string(nil), asg.overrideInstanceTypes...)
+	for _, spec := range asg.overrideTemplateSpecs {
+		data, err := describeLaunchTemplateVersion(asg, spec)
+		if err != nil {
+			return nil, err
+		}
+		if data.InstanceType != nil {
+			types = append(types, *data.InstanceType)
+		}
+	}
+	if !asg.hasLaunchData() {
+		return types, nil
+	}
+	data, err := describeLaunchTemplateData(asg)
+	if err != nil {
+		return nil, err
+	}
+	if data.InstanceType != nil {
+		types = append(types, *data.InstanceType)
+	}
+	return types, nil
+}
+
+// previousGenerationTypes returns the instance types of the ASG that AWS
+// reports as previous generation (m4, c4, ...)
+func previousGenerationTypes(asg *asgInfo, instanceTypes map[string]*ec2.InstanceTypeInfo) ([]string, error) {
+	types, err := asgInstanceTypes(asg)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []string
+	for _, instanceType := range types {
+		info, err := describeInstanceType(asg, instanceType, instanceTypes)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil && !aws.BoolValue(info.CurrentGeneration) {
+			previous = append(previous, instanceType)
+		}
+	}
+	return previous, nil
+}
+
+// applyGenerationPenalty lowers the score of the ASGs able to launch
+// previous-generation instance types by PREVIOUS_GENERATION_PENALTY, never
+// below zero
+func applyGenerationPenalty(matched []*asgInfo, scores map[string]int) {
+	instanceTypes := make(map[string]*ec2.InstanceTypeInfo)
+
+	for _, asg := range matched {
+		previous, err := previousGenerationTypes(asg, instanceTypes)
+		if err != nil {
+			fmt.Printf("Error resolving instance types for ASG %s: %v\n", asg.Name, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+			continue
+		}
+		if len(previous) == 0 {
+			continue
+		}
+
+		score := scores[asg.key()] - previousGenerationPenalty
+		if score < 0 {
+			score = 0
+		}
+		fmt.Printf("ASG %s uses previous-generation instance types (%s), score %d -> %d\n", asg.Name, strings.Join(previous, ", "), scores[asg.key()], score)
+		scores[asg.key()] = score
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gitOpsAnnotations are stamped on the managed configmap so the GitOps
+// controller leaves its contents to us
+var gitOpsAnnotations = map[string]map[string]string{
+	"argocd": {
+		// don't report the object as OutOfSync nor prune it when it isn't
+		// part of the application's manifests
+		"argocd.argoproj.io/compare-options": "IgnoreExtraneous",
+		"argocd.argoproj.io/sync-options":    "Prune=false",
+	},
+	"flux": {
+		// create the object if missing but never override our changes
+		"kustomize.toolkit.fluxcd.io/ssa":   "IfNotPresent",
+		"kustomize.toolkit.fluxcd.io/prune": "disabled",
+	},
+}
+
+// validGitOpsMode reports whether GITOPS_MODE holds a supported value
+func validGitOpsMode() bool {
+	if gitOpsMode == "" {
+		return true
+	}
+	_, ok := gitOpsAnnotations[gitOpsMode]
+	return ok
+}
+
+// stampGitOpsMetadata adds the ownership label and the annotations of the
+// configured GitOps controller, keeping any other metadata untouched
+func stampGitOpsMetadata(meta *metav1.ObjectMeta) {
+	if gitOpsMode == "" {
+		return
+	}
+
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	meta.Labels["app.kubernetes.io/managed-by"] = eventComponent
+
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	for key, value := range gitOpsAnnotations[gitOpsMode] {
+		meta.Annotations[key] = value
+	}
+}
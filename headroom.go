@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// SCORING_MODE values
+const (
+	scoringModeFreeIPs      = "free-ips"
+	scoringModeNodeHeadroom = "node-headroom"
+)
+
+// nodeHeadroomScorer scores ASGs by how many more nodes their free IPs can
+// take: the free IPs divided by the IPs a node of their hungriest instance
+// type consumes. ASGs whose nodes can't be sized score 0
+type nodeHeadroomScorer struct{}
+
+func (nodeHeadroomScorer) String() string { return scoringModeNodeHeadroom }
+
+func (nodeHeadroomScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	instanceTypes := make(map[string]*ec2.InstanceTypeInfo)
+	scores := make(map[string]int, len(asgs))
+	for _, asg := range asgs {
+		perNode, err := asgNodeIPs(asg, instanceTypes)
+		if err != nil {
+			return nil, fmt.Errorf("resolving the IPs per node of ASG %s: %v", asg.Name, err)
+		}
+		if perNode == 0 {
+			// unknown instance types: scoring the ASG on its free IPs would
+			// rank it against node counts, put it last instead
+			fmt.Printf("Unable to size the nodes of ASG %s, scoring it 0\n", asg.Name)
+			scores[asg.key()] = 0
+			continue
+		}
+		scores[asg.key()] = asg.FreeIPs / perNode
+		if debug {
+			fmt.Printf("DEBUG: %s has room for %d nodes of %d IPs\n", asg.Name, scores[asg.key()], perNode)
+		}
+	}
+	return scores, nil
+}
+
+// nodeIPs returns the IPs a node of the instance type takes from its subnet:
+// its primary address with IP_FAMILY ipv6, since pods get IPv6 addresses,
+// one per pod up to NODE_MAX_PODS, or else every address its ENIs can hold.
+// With PREFIX_DELEGATION pod addresses are taken in /28 prefixes.
+// NODE_IP_OVERHEAD is added to the first two for what they leave out, e.g.
+// daemonset pods, while the ENI capacity is already the most a node can take
+func nodeIPs(info *ec2.InstanceTypeInfo) int {
+	if ipFamily == ipFamilyIPv6 {
+		return 1 + nodeIPOverhead
+	}
+	if nodeMaxPods > 0 {
+		if prefixDelegation {
+			return (nodeMaxPods+ipv4PrefixSize-1)/ipv4PrefixSize*ipv4PrefixSize + 1 + nodeIPOverhead
+		}
+		return nodeMaxPods + 1 + nodeIPOverhead
+	}
+	if info == nil || info.NetworkInfo == nil {
+		return 0
+	}
+	enis := int(aws.Int64Value(info.NetworkInfo.MaximumNetworkInterfaces))
+	perENI := int(aws.Int64Value(info.NetworkInfo.Ipv4AddressesPerInterface))
+	if prefixDelegation && perENI > 0 {
+		return enis * ((perENI-1)*ipv4PrefixSize + 1)
+	}
+	return enis * perENI
+}
+
+// instanceFilterSize bounds the instance IDs of a single
+// DescribeNetworkInterfaces filter
+const instanceFilterSize = 200
+
+// observedNodeIPs returns the IPv4 addresses the ENIs of the ASG's instances
+// hold on average, the warm pools the VPC CNI keeps on them and their
+// delegated prefixes included, or 0 when it has no instances
+func observedNodeIPs(asg *asgInfo) (int, error) {
+	if len(asg.instanceIDs) == 0 {
+		return 0, nil
+	}
+	held := 0
+	for first := 0; first < len(asg.instanceIDs); first += instanceFilterSize {
+		last := first + instanceFilterSize
+		if last > len(asg.instanceIDs) {
+			last = len(asg.instanceIDs)
+		}
+		err := asg.api().ec2.DescribeNetworkInterfacesPages(&ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("attachment.instance-id"), Values: aws.StringSlice(asg.instanceIDs[first:last])}},
+		}, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+			for _, eni := range page.NetworkInterfaces {
+				held += len(eni.PrivateIpAddresses) + len(eni.Ipv4Prefixes)*ipv4PrefixSize
+			}
+			return !lastPage
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return (held + len(asg.instanceIDs) - 1) / len(asg.instanceIDs), nil
+}
+
+// asgNodeIPs returns the most IPs a node of any of the ASG's instance types
+// takes, or 0 when none of them is known. With OBSERVED_NODE_IPS the IPs its
+// running nodes actually hold are used instead, as new nodes will claim the
+// same warm pools
+func asgNodeIPs(asg *asgInfo, instanceTypes map[string]*ec2.InstanceTypeInfo) (int, error) {
+	if observeNodeIPs && ipFamily == ipFamilyIPv4 {
+		observed, err := observedNodeIPs(asg)
+		if err != nil {
+			return 0, err
+		}
+		if observed > 0 {
+			if debug {
+				fmt.Printf("DEBUG: nodes of %s hold %d IPs on average\n", asg.Name, observed)
+			}
+			return observed, nil
+		}
+	}
+
+	types, err := asgInstanceTypes(asg)
+	if err != nil {
+		return 0, err
+	}
+	most := 0
+	for _, instanceType := range types {
+		info, err := describeInstanceType(asg, instanceType, instanceTypes)
+		if err != nil {
+			return 0, err
+		}
+		if ips := nodeIPs(info); ips > most {
+			most = ips
+		}
+	}
+	return most, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestNodeHeadroomScoresUnsizedASGsZero(t *testing.T) {
+	homeClients = &awsClients{region: "us-east-1"}
+	asgs := []*asgInfo{{Name: "unknown-types", FreeIPs: 5000}}
+
+	scores := scoreWith(nodeHeadroomScorer{}, asgs)
+	if scores["unknown-types"] != 0 {
+		t.Errorf("score = %d, want 0 instead of its free IPs", scores["unknown-types"])
+	}
+}
+
+func TestNodeIPs(t *testing.T) {
+	defer func(family string, maxPods, overhead int, prefixes bool) {
+		ipFamily, nodeMaxPods, nodeIPOverhead, prefixDelegation = family, maxPods, overhead, prefixes
+	}(ipFamily, nodeMaxPods, nodeIPOverhead, prefixDelegation)
+
+	tests := []struct {
+		name     string
+		family   string
+		maxPods  int
+		overhead int
+		prefixes bool
+		want     int
+	}{
+		{name: "ipv6", family: ipFamilyIPv6, overhead: 2, want: 3},
+		{name: "max pods", family: ipFamilyIPv4, maxPods: 29, overhead: 1, want: 31},
+		{name: "max pods in prefixes", family: ipFamilyIPv4, maxPods: 20, prefixes: true, want: 33},
+		{name: "unknown type", family: ipFamilyIPv4, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipFamily, nodeMaxPods, nodeIPOverhead, prefixDelegation = tt.family, tt.maxPods, tt.overhead, tt.prefixes
+			if got := nodeIPs(nil); got != tt.want {
+				t.Errorf("nodeIPs() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// heartbeatDuration returns how long the heartbeat Lease stays valid:
+// HEARTBEAT_LEASE_DURATION, or three loop intervals
+func heartbeatDuration() time.Duration {
+	if heartbeatLeaseDuration > 0 {
+		return heartbeatLeaseDuration
+	}
+	if loopSleep > 0 {
+		return 3 * loopSleep
+	}
+	return 15 * time.Minute
+}
+
+// renewHeartbeat renews the HEARTBEAT_LEASE Lease after a successful
+// reconcile, so monitors can tell the loop stopped even if the Pod is still
+// running: the Lease is stale once renewTime plus leaseDurationSeconds has
+// passed
+func renewHeartbeat() error {
+	_, clientset, err := newKubernetesClient()
+	if err != nil {
+		return err
+	}
+
+	holder, _ := os.Hostname()
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(heartbeatDuration().Seconds())
+
+	leases := clientset.CoordinationV1().Leases(caNamespace)
+	lease, err := leases.Get(context.Background(), heartbeatLease, metav1.GetOptions{})
+	if err != nil {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: heartbeatLease},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		stampGitOpsMetadata(&lease.ObjectMeta)
+		_, err = leases.Create(context.Background(), lease, metav1.CreateOptions{})
+		return err
+	}
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+	if err == nil && debug {
+		fmt.Printf("DEBUG: renewed heartbeat lease %s/%s\n", caNamespace, heartbeatLease)
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hysteresis is the parsed HYSTERESIS setting: how much a score must move
+// before the ASG changes priority, in points or in percent of its score
+type hysteresis struct {
+	amount  float64
+	percent bool
+}
+
+// parseHysteresis parses HYSTERESIS, a number of points such as 50 or a
+// percentage such as 5%
+func parseHysteresis(value string) (hysteresis, error) {
+	if value == "" {
+		return hysteresis{}, nil
+	}
+	h := hysteresis{percent: strings.HasSuffix(value, "%")}
+	amount, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || amount < 0 {
+		return hysteresis{}, fmt.Errorf("invalid HYSTERESIS %q, expected a number of points or a percentage such as 5%%", value)
+	}
+	h.amount = amount
+	return h, nil
+}
+
+// holds reports whether a move from previous to score is within the band
+func (h hysteresis) holds(previous, score int) bool {
+	limit := h.amount
+	if h.percent {
+		limit = float64(abs(previous)) * h.amount / 100
+	}
+	return float64(abs(score-previous)) <= limit
+}
+
+// applyHysteresis keeps the previous score of the ASGs whose score moved by
+// no more than HYSTERESIS, so small IP fluctuations don't rewrite the
+// ConfigMap and flip CA's choices. Demotions and recoveries from
+// DEMOTED_SCORE always go through
+func applyHysteresis(scores map[string]int) {
+	h, err := parseHysteresis(hysteresisValue)
+	if err != nil || previousScores == nil {
+		// validateConfig already rejects invalid values
+		return
+	}
+	for name, score := range scores {
+		previous, ok := previousScores[name]
+		if !ok || previous == score || previous <= demotedScore || score <= demotedScore {
+			continue
+		}
+		if h.holds(previous, score) {
+			if debug {
+				fmt.Printf("DEBUG: %s score %d within HYSTERESIS of %d, keeping it\n", name, score, previous)
+			}
+			scores[name] = previous
+		}
+	}
+}
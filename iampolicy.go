@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type iamPolicyStatement struct {
+	Sid      string   `json:"Sid"`
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+type iamPolicyDocument struct {
+	Version   string               `json:"Version"`
+	Statement []iamPolicyStatement `json:"Statement"`
+}
+
+// iamPolicy builds the least-privilege policy for the enabled features:
+// read-only calls, tag mutations, metric publishing and role assumption each
+// get their own statement so reviewers can tell them apart
+func iamPolicy() iamPolicyDocument {
+	var describe, mutate, publish []string
+	for _, action := range requiredIAMActions() {
+		switch {
+		case strings.HasPrefix(action, "cloudwatch:Put"):
+			publish = append(publish, action)
+		case action == "sts:AssumeRole", action == "s3:GetObject":
+			// scoped to their resources below
+		case strings.Contains(action, ":Describe"), strings.Contains(action, ":List"), strings.Contains(action, ":Get"):
+			describe = append(describe, action)
+		default:
+			mutate = append(mutate, action)
+		}
+	}
+
+	policy := iamPolicyDocument{Version: "2012-10-17"}
+	policy.Statement = append(policy.Statement, iamPolicyStatement{
+		Sid:      "Discovery",
+		Effect:   "Allow",
+		Action:   describe,
+		Resource: []string{"*"},
+	})
+	if len(mutate) > 0 {
+		policy.Statement = append(policy.Statement, iamPolicyStatement{
+			Sid:      "ManageASGTags",
+			Effect:   "Allow",
+			Action:   mutate,
+			Resource: []string{"*"},
+		})
+	}
+	if len(publish) > 0 {
+		policy.Statement = append(policy.Statement, iamPolicyStatement{
+			Sid:      "PublishMetrics",
+			Effect:   "Allow",
+			Action:   publish,
+			Resource: []string{"*"},
+		})
+	}
+	if roles := assumableRoles(); len(roles) > 0 {
+		policy.Statement = append(policy.Statement, iamPolicyStatement{
+			Sid:      "AssumeRole",
+			Effect:   "Allow",
+			Action:   []string{"sts:AssumeRole"},
+			Resource: roles,
+		})
+	}
+	var objects []string
+	for _, source := range []string{rulesSource, rulesSHA256Source} {
+		if location := s3Location(source); strings.HasPrefix(source, "s3://") && len(location) == 2 {
+			objects = append(objects, "arn:"+awsPartition()+":s3:::"+location[0]+"/"+location[1])
+		}
+	}
+	if len(objects) > 0 {
+		policy.Statement = append(policy.Statement, iamPolicyStatement{
+			Sid:      "ReadRules",
+			Effect:   "Allow",
+			Action:   []string{"s3:GetObject"},
+			Resource: objects,
+		})
+	}
+	return policy
+}
+
+// assumableRoles lists every role the configuration may assume, so the
+// AssumeRole statement covers the extra accounts too
+func assumableRoles() []string {
+	var roles []string
+	if assumeRoleARN != "" {
+		roles = append(roles, assumeRoleARN)
+	}
+	roles = append(roles, assumeRoleARNs...)
+	if organizationRoleName != "" {
+		// member accounts are only listed at runtime
+		roles = append(roles, "arn:"+awsPartition()+":iam::*:role/"+organizationRoleName)
+	}
+	// shared subnet owners are often assumed for the ASGs as well
+	seen := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		seen[role] = true
+	}
+	for _, role := range sharedSubnetRoleARNs {
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// runGenerate prints the requested generated artifact, returning the process
+// exit code
+func runGenerate(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: generate iam-policy")
+		return exitConfigError
+	}
+
+	switch args[0] {
+	case "iam-policy":
+		policy, err := json.MarshalIndent(iamPolicy(), "", "  ")
+		if err != nil {
+			fmt.Printf("Error rendering IAM policy: %v\n", err)
+			return exitGenericError
+		}
+		fmt.Println(string(policy))
+		return exitOK
+	default:
+		fmt.Printf("Unknown generate target: %s\n", args[0])
+		return exitConfigError
+	}
+}
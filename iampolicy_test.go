@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// policyStatement returns the statement of policy with the given Sid
+func policyStatement(policy iamPolicyDocument, sid string) (iamPolicyStatement, bool) {
+	for _, statement := range policy.Statement {
+		if statement.Sid == sid {
+			return statement, true
+		}
+	}
+	return iamPolicyStatement{}, false
+}
+
+func TestIAMPolicyAssumeRoleCoversExtraAccounts(t *testing.T) {
+	defer func(role string, roles []string) {
+		assumeRoleARN, assumeRoleARNs = role, roles
+	}(assumeRoleARN, assumeRoleARNs)
+
+	assumeRoleARN = ""
+	assumeRoleARNs = []string{"arn:aws:iam::111111111111:role/autoconfig", "arn:aws:iam::222222222222:role/autoconfig"}
+
+	statement, ok := policyStatement(iamPolicy(), "AssumeRole")
+	if !ok {
+		t.Fatal("no AssumeRole statement for ASSUME_ROLE_ARNS")
+	}
+	if !reflect.DeepEqual(statement.Resource, assumeRoleARNs) {
+		t.Errorf("Resource = %v, want %v", statement.Resource, assumeRoleARNs)
+	}
+}
+
+func TestIAMPolicyAssumeRoleCoversOrganizationRole(t *testing.T) {
+	defer func(role string, roles []string, name string) {
+		assumeRoleARN, assumeRoleARNs, organizationRoleName = role, roles, name
+	}(assumeRoleARN, assumeRoleARNs, organizationRoleName)
+
+	assumeRoleARN, assumeRoleARNs = "", nil
+	organizationRoleName = "autoconfig"
+
+	statement, ok := policyStatement(iamPolicy(), "AssumeRole")
+	if !ok {
+		t.Fatal("no AssumeRole statement for ORGANIZATION_ROLE_NAME")
+	}
+	want := []string{"arn:" + awsPartition() + ":iam::*:role/autoconfig"}
+	if !reflect.DeepEqual(statement.Resource, want) {
+		t.Errorf("Resource = %v, want %v", statement.Resource, want)
+	}
+}
+
+func TestIAMPolicyAssumeRoleCoversSharedSubnetRoles(t *testing.T) {
+	defer func(role string, roles, shared []string, name string) {
+		assumeRoleARN, assumeRoleARNs, sharedSubnetRoleARNs, organizationRoleName = role, roles, shared, name
+	}(assumeRoleARN, assumeRoleARNs, sharedSubnetRoleARNs, organizationRoleName)
+
+	assumeRoleARN, organizationRoleName = "", ""
+	assumeRoleARNs = []string{"arn:aws:iam::111111111111:role/autoconfig"}
+	sharedSubnetRoleARNs = []string{"arn:aws:iam::111111111111:role/autoconfig", "arn:aws:iam::333333333333:role/network"}
+
+	statement, ok := policyStatement(iamPolicy(), "AssumeRole")
+	if !ok {
+		t.Fatal("no AssumeRole statement for SHARED_SUBNET_ROLE_ARNS")
+	}
+	want := []string{"arn:aws:iam::111111111111:role/autoconfig", "arn:aws:iam::333333333333:role/network"}
+	if !reflect.DeepEqual(statement.Resource, want) {
+		t.Errorf("Resource = %v, want %v", statement.Resource, want)
+	}
+}
+
+func TestIAMPolicyPublishesMetricsInOwnStatement(t *testing.T) {
+	defer func(publish bool) { cloudWatchPublish = publish }(cloudWatchPublish)
+	cloudWatchPublish = true
+
+	policy := iamPolicy()
+	if statement, ok := policyStatement(policy, "ManageASGTags"); ok {
+		for _, action := range statement.Action {
+			if action == "cloudwatch:PutMetricData" {
+				t.Error("cloudwatch:PutMetricData granted under ManageASGTags")
+			}
+		}
+	}
+	statement, ok := policyStatement(policy, "PublishMetrics")
+	if !ok {
+		t.Fatal("no PublishMetrics statement with CLOUDWATCH_METRICS")
+	}
+	if want := []string{"cloudwatch:PutMetricData"}; !reflect.DeepEqual(statement.Action, want) {
+		t.Errorf("Action = %v, want %v", statement.Action, want)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// kubernetesArchitectures maps the EC2 processor architectures to the
+// kubernetes.io/arch values
+var kubernetesArchitectures = map[string]string{
+	"x86_64":     "amd64",
+	"x86_64_mac": "amd64",
+	"arm64":      "arm64",
+	"arm64_mac":  "arm64",
+	"i386":       "386",
+}
+
+// describeInstanceType returns the metadata of the instance type, caching it
+// in instanceTypes across the ASGs of a single run. Unknown types are
+// cached as nil
+func describeInstanceType(asg *asgInfo, instanceType string, instanceTypes map[string]*ec2.InstanceTypeInfo) (*ec2.InstanceTypeInfo, error) {
+	if info, ok := instanceTypes[instanceType]; ok {
+		return info, nil
+	}
+
+	output, err := asg.api().ec2.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []*string{aws.String(instanceType)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var info *ec2.InstanceTypeInfo
+	if len(output.InstanceTypes) > 0 {
+		info = output.InstanceTypes[0]
+	}
+	instanceTypes[instanceType] = info
+	return info, nil
+}
+
+// asgArchitectures returns the kubernetes.io/arch values of the instance
+// types the ASG can launch. i386 is left out of the x86_64 types that also
+// support it
+func asgArchitectures(asg *asgInfo, types []string, instanceTypes map[string]*ec2.InstanceTypeInfo) ([]string, error) {
+	found := make(map[string]bool)
+	for _, instanceType := range types {
+		info, err := describeInstanceType(asg, instanceType, instanceTypes)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil || info.ProcessorInfo == nil {
+			continue
+		}
+		supported := aws.StringValueSlice(info.ProcessorInfo.SupportedArchitectures)
+		for _, architecture := range supported {
+			if architecture == "i386" && len(supported) > 1 {
+				continue
+			}
+			if arch, ok := kubernetesArchitectures[architecture]; ok {
+				found[arch] = true
+			}
+		}
+	}
+
+	architectures := make([]string, 0, len(found))
+	for arch := range found {
+		architectures = append(architectures, arch)
+	}
+	sort.Strings(architectures)
+	return architectures, nil
+}
+
+// instanceTypeFilterEnabled reports whether any INSTANCE_TYPE_* or
+// ARCHITECTURE_* rule is set, or GPU_NODE_GROUPS filters the groups
+func instanceTypeFilterEnabled() bool {
+	return len(instanceTypeInclude) > 0 || len(instanceTypeExclude) > 0 || len(architectureInclude) > 0 || len(architectureExclude) > 0 ||
+		gpuNodeGroups == gpuNodeGroupsExclude || gpuNodeGroups == gpuNodeGroupsOnly
+}
+
+// instanceTypeRejection returns why the instance types configured for the
+// ASG aren't allowed by INSTANCE_TYPE_INCLUDE, INSTANCE_TYPE_EXCLUDE,
+// GPU_NODE_GROUPS, ARCHITECTURE_INCLUDE and ARCHITECTURE_EXCLUDE, or an
+// empty string when they are. Every type the ASG can launch must be
+// included and none excluded. ASGs whose types can't be resolved are
+// rejected
+func instanceTypeRejection(asg *asgInfo, instanceTypes map[string]*ec2.InstanceTypeInfo) string {
+	types, err := asgInstanceTypes(asg)
+	if err != nil {
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		return fmt.Sprintf("unable to resolve instance types: %v", err)
+	}
+	if len(types) == 0 {
+		return "no instance type configured"
+	}
+
+	var rejected []string
+	for _, instanceType := range types {
+		if (len(instanceTypeInclude) > 0 && !matchesAny(instanceType, instanceTypeInclude)) || matchesAny(instanceType, instanceTypeExclude) {
+			rejected = append(rejected, instanceType)
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Sprintf("instance types %s are not allowed", strings.Join(rejected, ", "))
+	}
+
+	if reason := gpuRejection(asg, instanceTypes); reason != "" {
+		return reason
+	}
+
+	if len(architectureInclude) == 0 && len(architectureExclude) == 0 {
+		return ""
+	}
+	architectures, err := asgArchitectures(asg, types, instanceTypes)
+	if err != nil {
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		return fmt.Sprintf("unable to resolve architectures: %v", err)
+	}
+	for _, arch := range architectures {
+		if (len(architectureInclude) > 0 && !matchesAny(arch, architectureInclude)) || matchesAny(arch, architectureExclude) {
+			rejected = append(rejected, arch)
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Sprintf("architectures %s are not allowed", strings.Join(rejected, ", "))
+	}
+	return ""
+}
+
+// GPU_NODE_GROUPS values, telling what to do with the ASGs whose instance
+// types carry GPUs or inference accelerators
+const (
+	gpuNodeGroupsInclude = "include"
+	gpuNodeGroupsExclude = "exclude"
+	gpuNodeGroupsOnly    = "only"
+	gpuNodeGroupsDemote  = "demote"
+)
+
+// validGPUNodeGroups reports whether GPU_NODE_GROUPS is supported
+func validGPUNodeGroups() bool {
+	switch gpuNodeGroups {
+	case gpuNodeGroupsInclude, gpuNodeGroupsExclude, gpuNodeGroupsOnly, gpuNodeGroupsDemote:
+		return true
+	}
+	return false
+}
+
+// asgAccelerators returns the instance types of the ASG that carry GPUs or
+// inference accelerators
+func asgAccelerators(asg *asgInfo, instanceTypes map[string]*ec2.InstanceTypeInfo) ([]string, error) {
+	types, err := asgInstanceTypes(asg)
+	if err != nil {
+		return nil, err
+	}
+
+	var accelerated []string
+	for _, instanceType := range types {
+		info, err := describeInstanceType(asg, instanceType, instanceTypes)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			continue
+		}
+		if (info.GpuInfo != nil && len(info.GpuInfo.Gpus) > 0) || (info.InferenceAcceleratorInfo != nil && len(info.InferenceAcceleratorInfo.Accelerators) > 0) {
+			accelerated = append(accelerated, instanceType)
+		}
+	}
+	return accelerated, nil
+}
+
+// gpuRejection returns why the ASG is left out by GPU_NODE_GROUPS exclude or
+// only, or an empty string when it is kept
+func gpuRejection(asg *asgInfo, instanceTypes map[string]*ec2.InstanceTypeInfo) string {
+	if gpuNodeGroups != gpuNodeGroupsExclude && gpuNodeGroups != gpuNodeGroupsOnly {
+		return ""
+	}
+	accelerated, err := asgAccelerators(asg, instanceTypes)
+	if err != nil {
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		return fmt.Sprintf("unable to resolve accelerators: %v", err)
+	}
+	if gpuNodeGroups == gpuNodeGroupsExclude && len(accelerated) > 0 {
+		return fmt.Sprintf("GPU node group (%s)", strings.Join(accelerated, ", "))
+	}
+	if gpuNodeGroups == gpuNodeGroupsOnly && len(accelerated) == 0 {
+		return "not a GPU node group"
+	}
+	return ""
+}
+
+// applyGPUDemotion demotes the GPU node groups to DEMOTED_SCORE so CA only
+// falls back to them once every other group has been tried
+func applyGPUDemotion(matched []*asgInfo, scores map[string]int, status statusReport, instanceTypes map[string]*ec2.InstanceTypeInfo) {
+	for _, asg := range matched {
+		accelerated, err := asgAccelerators(asg, instanceTypes)
+		if err != nil {
+			fmt.Printf("Error resolving instance types for ASG %s: %v\n", asg.Name, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+			continue
+		}
+		if len(accelerated) > 0 {
+			demoteASG(scores, status, asg.key(), "GPU node group ("+strings.Join(accelerated, ", ")+")")
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// IP_FAMILY values, the address family of the pods of the cluster
+const (
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+)
+
+// ipv6NodePrefix is the size of the IPv6 prefix the VPC CNI assigns to each
+// node of IPv6 clusters
+const ipv6NodePrefix = 80
+
+// ipv6NodePrefixes returns how many node prefixes fit in the IPv6 CIDRs
+// associated with the subnet
+func ipv6NodePrefixes(subnet *ec2.Subnet) int {
+	prefixes := 0
+	for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+		if association.Ipv6CidrBlockState == nil || aws.StringValue(association.Ipv6CidrBlockState.State) != ec2.SubnetCidrBlockStateCodeAssociated {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(aws.StringValue(association.Ipv6CidrBlock))
+		if err != nil {
+			continue
+		}
+		if ones, _ := cidr.Mask.Size(); ones <= ipv6NodePrefix {
+			prefixes += 1 << uint(ipv6NodePrefix-ones)
+		}
+	}
+	return prefixes
+}
+
+// subnetFreeIPs returns the free IPs scored for the subnet. With IP_FAMILY
+// ipv4 that's its free IPv4 addresses. With ipv6 the pods take IPv6 addresses
+// that don't run out, so dual-stack subnets are scored on the IPv4 addresses
+// their nodes still take, IPv6-only subnets on the node prefixes they hold
+// and subnets without IPv6 get nothing since their pods can't start
+func subnetFreeIPs(subnet *ec2.Subnet, freeIPv4 int) int {
+	if ipFamily != ipFamilyIPv6 {
+		return freeIPv4
+	}
+	prefixes := ipv6NodePrefixes(subnet)
+	if aws.BoolValue(subnet.Ipv6Native) {
+		return prefixes
+	}
+	if prefixes == 0 {
+		return 0
+	}
+	return freeIPv4
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ec2NodeClassSubnets returns the subnets an EC2NodeClass launches into:
+// the ones Karpenter already resolved in its status or, if not available
+// yet, the ones its subnetSelectorTerms select
+func ec2NodeClassSubnets(nodeClass unstructured.Unstructured) ([]string, error) {
+	var subnetIDs []string
+
+	resolved, _, _ := unstructured.NestedSlice(nodeClass.Object, "status", "subnets")
+	for _, item := range resolved {
+		if subnet, ok := item.(map[string]interface{}); ok {
+			if id, ok := subnet["id"].(string); ok {
+				subnetIDs = append(subnetIDs, id)
+			}
+		}
+	}
+	if len(subnetIDs) > 0 {
+		return subnetIDs, nil
+	}
+
+	terms, _, _ := unstructured.NestedSlice(nodeClass.Object, "spec", "subnetSelectorTerms")
+	for _, item := range terms {
+		term, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, ok := term["id"].(string); ok {
+			subnetIDs = append(subnetIDs, id)
+			continue
+		}
+
+		tags, _, _ := unstructured.NestedStringMap(term, "tags")
+		if len(tags) == 0 {
+			continue
+		}
+		var filters []*ec2.Filter
+		for key, value := range tags {
+			if value == "*" {
+				filters = append(filters, &ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(key)}})
+			} else {
+				filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + key), Values: []*string{aws.String(value)}})
+			}
+		}
+		err := ec2Client.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{Filters: filters},
+			func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+				for _, subnet := range page.Subnets {
+					subnetIDs = append(subnetIDs, *subnet.SubnetId)
+				}
+				return !lastPage
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return subnetIDs, nil
+}
+
+// validateKarpenterSubnets cross-checks the subnets used by every
+// EC2NodeClass against the free IPs measured during this run, raising an
+// alert for the exhausted ones
+func validateKarpenterSubnets(config *rest.Config, clientset kubernetes.Interface, subnetFreeIPs map[string]int, status statusReport) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		fmt.Printf("Unable to create dynamic Kubernetes client: %v\n", err)
+		return
+	}
+
+	nodeClasses, err := dynamicClient.Resource(schema.GroupVersionResource{
+		Group:    "karpenter.k8s.aws",
+		Version:  karpenterAPIVersion,
+		Resource: "ec2nodeclasses",
+	}).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		fmt.Printf("Error listing Karpenter EC2NodeClasses: %v\n", err)
+		return
+	}
+
+	for _, nodeClass := range nodeClasses.Items {
+		subnetIDs, err := ec2NodeClassSubnets(nodeClass)
+		if err != nil {
+			fmt.Printf("Error resolving subnets for EC2NodeClass %s: %v\n", nodeClass.GetName(), err)
+			continue
+		}
+
+		for _, subnetID := range subnetIDs {
+			freeIPs, ok := subnetFreeIPs[subnetID]
+			if !ok {
+				// not used by any matched ASG, measure it now
+				subnet, err := ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{
+					SubnetIds: []*string{aws.String(subnetID)},
+				})
+				if err != nil || len(subnet.Subnets) == 0 {
+					fmt.Printf("Error describing subnet %s for EC2NodeClass %s: %v\n", subnetID, nodeClass.GetName(), err)
+					continue
+				}
+				freeIPs = int(*subnet.Subnets[0].AvailableIpAddressCount)
+				subnetFreeIPs[subnetID] = freeIPs
+			}
+
+			if debug {
+				fmt.Printf("DEBUG: EC2NodeClass %s subnet %s has %d free IPs\n", nodeClass.GetName(), subnetID, freeIPs)
+			}
+
+			if freeIPs < karpenterMinFreeIPs {
+				message := fmt.Sprintf("EC2NodeClass %s uses subnet %s with only %d free IPs", nodeClass.GetName(), subnetID, freeIPs)
+				fmt.Println(message)
+				status.add("karpenterExhaustedSubnets", fmt.Sprintf("%s/%s", nodeClass.GetName(), subnetID))
+				recordEvent(clientset, v1.EventTypeWarning, "KarpenterSubnetExhausted", message)
+			}
+		}
+	}
+}
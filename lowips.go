@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// checkLowFreeIPs warns about the measured subnets with fewer free IPs than
+// SUBNET_LOW_IP_THRESHOLD before they are exhausted and scale-ups start
+// failing
+func checkLowFreeIPs(clientset kubernetes.Interface, subnetSamples []metricSample, status statusReport) {
+	low := 0
+	for _, sample := range subnetSamples {
+		subnetID := sample.labels["subnet"]
+		if int(sample.value) >= subnetLowIPThreshold {
+			continue
+		}
+		message := fmt.Sprintf("Subnet %s has only %d free IPs, below SUBNET_LOW_IP_THRESHOLD %d", subnetID, int(sample.value), subnetLowIPThreshold)
+		fmt.Println(message)
+		low++
+		// the count changes every run, it's in the event and the metrics
+		status.add("lowFreeIPSubnets", fmt.Sprintf("%s: free IPs below SUBNET_LOW_IP_THRESHOLD %d", subnetID, subnetLowIPThreshold))
+		recordEvent(clientset, v1.EventTypeWarning, "SubnetLowFreeIPs", message)
+	}
+
+	metrics.setGauge(metricLowFreeIPSubnets, profileLabels(), float64(low))
+}
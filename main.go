@@ -3,9 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
-	"sort"
-	"strconv"
+	"reflect"
 	"strings"
 	"time"
 
@@ -13,63 +13,140 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/rest"
 )
 
 var (
+	awsSession        *session.Session
 	autoscalingClient *autoscaling.AutoScaling
 	ec2Client         *ec2.EC2
-
-	setRegion          = os.Getenv("REGION")
-	caNamespace        = os.Getenv("CA_NAMESPACE")
-	caPriorityExpander = "cluster-autoscaler-priority-expander"
-	asgContains        = os.Getenv("ASG_CONTAINS")
-	ltContains         = os.Getenv("LT_CONTAINS")
-	sleepMinutesEnv    = os.Getenv("SLEEP_MINUTES")
-	sleepMinutes       int
-	loopSleep          time.Duration
-	catchAllEnv        = os.Getenv("CATCH_ALL")
-	catchAll           bool
-	debugEnv           = os.Getenv("DEBUG")
-	debug              bool
-	skipCMCreationEnv  = os.Getenv("SKIP_CM_CREATION")
-	skipCMCreation     bool
+	eksClient         *eks.EKS
 )
 
 func init() {
 	// Parse environment variables
-	sleepMinutes, _ = strconv.Atoi(sleepMinutesEnv)
-	loopSleep = time.Duration(sleepMinutes) * time.Minute
-	catchAll, _ = strconv.ParseBool(catchAllEnv)
-	debug, _ = strconv.ParseBool(debugEnv)
-	skipCMCreation, _ = strconv.ParseBool(skipCMCreationEnv)
+	loadConfig(os.Getenv)
+	if setRegion == "" {
+		detectedRegion = imdsRegion()
+		setRegion = detectedRegion
+	}
 
 	// Initialize AWS clients
-	sess := session.Must(session.NewSession())
-	autoscalingClient = autoscaling.New(sess, &aws.Config{Region: &setRegion})
-	ec2Client = ec2.New(sess, &aws.Config{Region: &setRegion})
+	awsSession = newAWSSession()
+	autoscalingClient = autoscaling.New(awsSession, &aws.Config{Region: &setRegion})
+	ec2Client = ec2.New(awsSession, &aws.Config{Region: &setRegion})
+	eksClient = eks.New(awsSession, &aws.Config{Region: &setRegion})
+	homeClients = &awsClients{region: setRegion, session: awsSession, autoscaling: autoscalingClient, ec2: ec2Client, eks: eksClient}
+	regionClients = newRegionClients(awsSession)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck())
+		case "generate":
+			os.Exit(runGenerate(os.Args[2:]))
+		case "tui":
+			os.Exit(runTUI())
+		default:
+			fmt.Printf("Unknown command: %s\n", os.Args[1])
+			os.Exit(exitConfigError)
+		}
+	}
+
+	if err := validateConfig(); err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(exitConfigError)
+	}
+	if err := validateProfiles(os.Getenv); err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		os.Exit(exitConfigError)
+	}
+
+	if rulesSource != "" {
+		if err := refreshRules(); err != nil {
+			fmt.Printf("Unable to load rules from %s: %v\n", rulesSource, err)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	if externalMetricsAddr != "" {
+		go serveExternalMetrics()
+	}
+
+	var backoff time.Duration
 	for {
+		if rulesSource != "" && time.Since(rulesLoadedAt) >= rulesRefresh {
+			if err := refreshRules(); err != nil {
+				fmt.Printf("Unable to refresh rules from %s, keeping the current ones: %v\n", rulesSource, err)
+				metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "rules"}, 1)
+			}
+		}
+
 		fmt.Println("Running CA autoconfig...")
-		mainLoop()
+		start := time.Now()
+		err := mainLoop()
+		metrics.observe(metricReconcileDuration, nil, time.Since(start).Seconds())
+		metrics.incCounter(metricReconcileTotal, map[string]string{"outcome": reconcileOutcomes[exitCode(err)]}, 1)
+		metrics.incCounter(metricRunsTotal, nil, 1)
+		if err == nil && heartbeatLease != "" {
+			if err := renewHeartbeat(); err != nil {
+				fmt.Printf("Unable to renew heartbeat lease %s/%s: %v\n", caNamespace, heartbeatLease, err)
+				metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "heartbeat"}, 1)
+			}
+		}
+		metrics.setGauge(metricLastRunTime, nil, float64(time.Now().Unix()))
+		publishMetrics()
 		if !debug {
+			if err != nil {
+				backoff = nextBackoff(backoff)
+				fmt.Printf("Reconcile failed, retrying in %s...\n", backoff)
+				time.Sleep(backoff)
+				continue
+			}
+			backoff = 0
+			if schedule != nil {
+				next := schedule.next(time.Now())
+				if next.IsZero() {
+					fmt.Printf("SCHEDULE %q never matches again, exiting...\n", scheduleExpr)
+					os.Exit(exitConfigError)
+				}
+				fmt.Printf("Sleeping until %s...\n", next.Format(time.RFC3339))
+				time.Sleep(time.Until(next))
+				continue
+			}
 			fmt.Printf("Sleeping for %d minute(s)...\n", sleepMinutes)
 			time.Sleep(loopSleep)
 		} else {
 			fmt.Println("DEBUG mode: exiting...")
-			break
+			os.Exit(exitCode(err))
 		}
 	}
 }
 
-func mainLoop() {
-	caPriorities := make(map[int][]string)
+// nextBackoff doubles the retry delay after a failed reconcile, starting at
+// RETRY_INITIAL_BACKOFF and never exceeding RETRY_MAX_BACKOFF nor the normal
+// loop interval
+func nextBackoff(current time.Duration) time.Duration {
+	next := current * 2
+	if next < retryInitialBackoff {
+		next = retryInitialBackoff
+	}
+	if next > retryMaxBackoff {
+		next = retryMaxBackoff
+	}
+	if loopSleep > 0 && next > loopSleep {
+		next = loopSleep
+	}
+	return next
+}
 
+func mainLoop() error {
 	if debug {
 		fmt.Println("DEBUG: mainLoop()")
 
@@ -77,140 +154,765 @@ func mainLoop() {
 			fmt.Println("DEBUG: ASG_CONTAINS: " + asgContains)
 		}
 
+		if asgMatchRegex != nil {
+			fmt.Println("DEBUG: ASG_MATCH_REGEX: " + asgMatchRegex.String())
+		}
+
+		if asgExcludesValue != "" {
+			fmt.Println("DEBUG: ASG_EXCLUDES: " + asgExcludesValue)
+		}
+
+		if asgTagSelectorValue != "" {
+			fmt.Println("DEBUG: ASG_TAG_SELECTOR: " + asgTagSelectorValue)
+		}
+
+		if len(regions) > 0 {
+			fmt.Println("DEBUG: REGIONS: " + strings.Join(regions, ","))
+		}
+
+		if eksNodegroups {
+			fmt.Println("DEBUG: EKS_NODEGROUPS: " + clusterName)
+		}
+
+		if caAutoDiscovery {
+			fmt.Println("DEBUG: CA_AUTO_DISCOVERY: " + caEnabledTag + "," + caClusterTag())
+		}
+
 		if ltContains != "" {
 			fmt.Println("DEBUG: LT_CONTAINS: " + ltContains)
 		}
+
+		if ltMatchRegex != nil {
+			fmt.Println("DEBUG: LT_MATCH_REGEX: " + ltMatchRegex.String())
+		}
+	}
+
+	discoveryStart := time.Now()
+	asgs, err := awsSearchEC2ASGs()
+	if err != nil {
+		return newRunError(exitAWSDiscoveryError, err)
+	}
+	listing := time.Since(discoveryStart)
+
+	// subnets are described once and shared by every profile
+	subnets := newSubnetInventory()
+	subnets.prefetch(asgs)
+	defer func() {
+		metrics.observe(metricDiscoveryDuration, nil, (listing + subnets.elapsed).Seconds())
+	}()
+
+	if len(profiles) == 0 {
+		return reconcile(asgs, subnets)
+	}
+
+	var firstErr error
+	for _, name := range append([]string(nil), profiles...) {
+		err := withProfile(name, func() error {
+			fmt.Printf("Reconciling profile %s...\n", name)
+			return reconcile(profileASGs(asgs), subnets)
+		})
+		if err != nil {
+			fmt.Printf("Profile %s failed: %v\n", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
+	return firstErr
+}
+
+// priorityPlan is what computePriorities worked out: the matched ASGs with
+// their final scores, the names listed at each priority and the status
+type priorityPlan struct {
+	matched      []*asgInfo
+	excluded     []*asgInfo
+	scores       map[string]int
+	caPriorities map[int][]string
+	status       statusReport
+
+	freeIPSamples, subnetSamples, prioritySamples []metricSample
+}
 
-	for _, asg := range awsSearchEC2ASGByName(asgContains) {
+// reconcile filters and scores the discovered ASGs with the active settings
+// and writes the resulting priorities to the configmap
+func reconcile(asgs []*asgInfo, subnets *subnetInventory) error {
+	// Initialize Kubernetes client
+	config, clientset, err := newKubernetesClient()
+	if err != nil {
+		fmt.Printf("Unable to create Kubernetes client: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "kubernetes"}, 1)
+		return newRunError(exitKubernetesError, err)
+	}
+
+	plan, err := computePriorities(asgs, subnets, clientset)
+	if err != nil {
+		return err
+	}
+	return writePriorities(plan, subnets, config, clientset)
+}
+
+// computePriorities filters, measures and scores the ASGs the way a
+// reconcile does, without writing the priorities anywhere. Without a
+// clientset the steps needing the cluster are skipped
+func computePriorities(asgs []*asgInfo, subnets *subnetInventory, clientset kubernetes.Interface) (*priorityPlan, error) {
+	caPriorities := make(map[int][]string)
+	var freeIPSamples, subnetSamples, prioritySamples []metricSample
+	var matchedASGs, excludedASGs, measuredASGs []*asgInfo
+	var staleASGs, rejectedImages, misroutedSubnets []string
+	images := make(map[string]*ec2.Image)
+	instanceTypes := make(map[string]*ec2.InstanceTypeInfo)
+	status := make(statusReport)
+
+	// with custom networking pods take their addresses from other subnets
+	// than their nodes, and those run out first
+	var podSubnets podSubnetMapping
+	if customNetworking {
+		var err error
+		podSubnets, err = loadPodSubnets()
+		if err != nil {
+			fmt.Printf("Unable to read the pod subnets: %v\n", err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "kubernetes"}, 1)
+			return nil, newRunError(exitKubernetesError, err)
+		}
+	}
+
+	// every discovered ASG launching instances takes IPs from its subnets,
+	// selected or not
+	var pendingIPs map[string]int
+	if subtractPendingInstances {
+		pendingIPs = pendingSubnetIPs(asgs, instanceTypes, func(asg *asgInfo, subnetID string) string {
+			if podSubnets == nil {
+				return subnetID
+			}
+			// lookup errors are reported when the ASG is measured
+			if _, err := subnets.lookup(asg, subnetID); err != nil {
+				return subnetID
+			}
+			return podSubnets.podSubnet(subnets.subnets[subnetID])
+		})
+	}
+
+	for _, asg := range asgs {
+		if !asgSelected(asg) {
+			continue
+		}
 		if debug {
-			fmt.Println("considering ASG: " + *asg.AutoScalingGroupName)
+			fmt.Println("considering ASG: " + asg.Name)
+		}
+		if reason := asg.inactiveReason(); reason != "" {
+			fmt.Printf("Skipping ASG %s: %s\n", asg.Name, reason)
+			status.add("inactive", asg.Name+": "+reason)
+			continue
+		}
+		if instanceRefreshAction == instanceRefreshExclude {
+			if refresh := checkInstanceRefresh(asg); refresh != "" {
+				fmt.Printf("Skipping ASG %s: %s\n", asg.Name, refresh)
+				status.add("instanceRefresh", asg.Name+": "+refresh)
+				continue
+			}
 		}
 
-		var ltName string
-		if asg.LaunchTemplate != nil {
-			ltName = *asg.LaunchTemplate.LaunchTemplateName
-		} else {
-			ltName = *asg.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification.LaunchTemplateName
+		matched := launchMatches(asg)
+		if matched && amiFilterEnabled() {
+			if reason := amiRejection(asg, images); reason != "" {
+				fmt.Printf("Excluding ASG %s: %s\n", asg.Name, reason)
+				rejectedImages = append(rejectedImages, asg.Name+": "+reason)
+				matched = false
+			}
+		}
+		if matched && instanceTypeFilterEnabled() {
+			if reason := instanceTypeRejection(asg, instanceTypes); reason != "" {
+				fmt.Printf("Excluding ASG %s: %s\n", asg.Name, reason)
+				matched = false
+			}
 		}
 
-		if strings.Contains(ltName, ltContains) {
+		if matched {
+			matchedASGs = append(matchedASGs, asg)
 			if debug {
-				fmt.Println("retrieving free IPs for LT: " + ltName)
+				fmt.Println("retrieving free IPs for LT: " + asg.launchName())
 			}
-			freeIPs := 0
-			for _, subnetID := range strings.Split(*asg.VPCZoneIdentifier, ",") {
-				subnet, _ := ec2Client.DescribeSubnets(&ec2.DescribeSubnetsInput{
-					SubnetIds: []*string{&subnetID},
-				})
-				freeIPs += int(*subnet.Subnets[0].AvailableIpAddressCount)
+			asg.Subnets = make(map[string]int, len(asg.subnetIDs))
+			asg.subnetZones = make(map[string]string, len(asg.subnetIDs))
+			stale := false
+			for _, subnetID := range asg.subnetIDs {
+				scoredID := subnetID
+				freeIPs, err := subnets.lookup(asg, subnetID)
+				if err == nil && podSubnets != nil {
+					if podSubnetID := podSubnets.podSubnet(subnets.subnets[subnetID]); podSubnetID != subnetID {
+						if debug {
+							fmt.Printf("DEBUG: pods of %s in subnet %s use subnet %s\n", asg.Name, subnetID, podSubnetID)
+						}
+						scoredID = podSubnetID
+						freeIPs, err = subnets.lookup(asg, podSubnetID)
+					}
+				}
+				if err != nil {
+					fmt.Printf("Error describing subnet %s: %v\n", scoredID, err)
+					metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+					if _, ok := previousScores[asg.key()]; !ok {
+						return nil, newRunError(exitAWSDiscoveryError, fmt.Errorf("describing subnet %s: %v", scoredID, err))
+					}
+					// keep the previous score rather than demoting the ASG
+					staleASGs = append(staleASGs, asg.key())
+					stale = true
+					break
+				}
+				if reason := subnetExclusion(subnets.subnets[scoredID]); reason != "" {
+					if debug {
+						fmt.Printf("DEBUG: ignoring subnet %s of ASG %s: %s\n", scoredID, asg.Name, reason)
+					}
+					continue
+				}
+				if validateSubnetRoutes {
+					problem, err := subnets.routeProblem(subnetID)
+					if err != nil {
+						fmt.Printf("Error checking routes of subnet %s: %v\n", subnetID, err)
+						metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+					} else if problem != "" {
+						// nodes launched there never become Ready, don't count it
+						fmt.Printf("Ignoring subnet %s of ASG %s: %s\n", subnetID, asg.Name, problem)
+						misroutedSubnets = append(misroutedSubnets, asg.Name+"/"+subnetID+": "+problem)
+						continue
+					}
+				}
+				if _, counted := asg.Subnets[scoredID]; counted {
+					// another node subnet of the ASG shares the pod subnet
+					continue
+				}
+				if pending := pendingIPs[scoredID]; pending > 0 {
+					freeIPs -= pending
+					if freeIPs < 0 {
+						freeIPs = 0
+					}
+				}
+				if freeIPsAsPercent {
+					freeIPs = freeIPsPercent(subnets.subnets[scoredID], freeIPs)
+				}
+				freeIPs = weighSubnet(subnets.subnets[scoredID], freeIPs)
+				asg.Subnets[scoredID] = freeIPs
+				asg.subnetZones[scoredID] = aws.StringValue(subnets.subnets[scoredID].AvailabilityZone)
+				asg.FreeIPs += freeIPs
 			}
 
-			if _, ok := caPriorities[freeIPs]; !ok {
-				caPriorities[freeIPs] = []string{*asg.AutoScalingGroupName}
-			} else {
-				caPriorities[freeIPs] = append(caPriorities[freeIPs], *asg.AutoScalingGroupName)
+			if !stale {
+				measuredASGs = append(measuredASGs, asg)
 			}
+		} else {
+			excludedASGs = append(excludedASGs, asg)
+		}
+	}
+
+	if sharedSubnetAccounting == sharedSubnetsSplit {
+		apportionSharedSubnets(measuredASGs)
+	} else if subnetAggregation != subnetAggregationSum {
+		aggregateSubnets(measuredASGs)
+	}
+	if smoothingRuns > 1 {
+		smoothFreeIPs(measuredASGs, subnets)
+	}
+	if minFreeIPs > 0 && minFreeIPsAction == minFreeIPsExclude {
+		dropped := excludeBelowMinFreeIPs(measuredASGs, status)
+		measuredASGs = withoutASGs(measuredASGs, dropped)
+		matchedASGs = withoutASGs(matchedASGs, dropped)
+	}
+
+	measuredSubnets := make(map[string]bool)
+	for _, asg := range measuredASGs {
+		freeIPSamples = append(freeIPSamples, metricSample{
+			labels: map[string]string{"asg": asg.key()},
+			value:  float64(asg.FreeIPs),
+		})
+		for subnetID := range asg.Subnets {
+			if !measuredSubnets[subnetID] {
+				measuredSubnets[subnetID] = true
+				subnetSamples = append(subnetSamples, metricSample{
+					labels: map[string]string{"subnet": subnetID, "zone": aws.StringValue(subnets.subnets[subnetID].AvailabilityZone)},
+					value:  float64(subnets.freeIPs[subnetID]),
+				})
+			}
+		}
 
+		if debug {
+			fmt.Printf("%s/%s has %d free IPs\n", asg.Name, asg.launchName(), asg.FreeIPs)
+		}
+	}
+
+	scores := scoreASGs(matchedASGs)
+	if shadowScoring != "" {
+		evaluateShadow(matchedASGs, scores, status)
+	}
+	if previousGenerationPenalty > 0 {
+		applyGenerationPenalty(matchedASGs, scores)
+	}
+	if learnOutcomes && clientset != nil {
+		applyOutcomeLearning(clientset, matchedASGs, scores)
+	}
+	if checkServiceQuotas {
+		applyQuotaDemotion(matchedASGs, scores, status)
+	}
+	if gpuNodeGroups == gpuNodeGroupsDemote {
+		applyGPUDemotion(matchedASGs, scores, status, instanceTypes)
+	}
+	if instanceRefreshAction == instanceRefreshDemote {
+		applyInstanceRefreshDemotion(matchedASGs, scores, status)
+	}
+	if edgeSubnets != "" {
+		applyEdgeSubnets(matchedASGs, subnets, scores, status)
+	}
+	if exhaustionHorizon > 0 && clientset != nil {
+		applyExhaustionForecast(clientset, matchedASGs, subnets, subnetSamples, scores, status)
+	}
+	if spotInterruptionThreshold > 0 {
+		applySpotInterruptionDemotion(matchedASGs, scores, status)
+	}
+	if minFreeIPs > 0 && minFreeIPsAction == minFreeIPsDemote {
+		applyMinFreeIPsDemotion(measuredASGs, scores, status)
+	}
+	if priorityNormalization == priorityNormalizationBands {
+		scores = bandScores(scores)
+	}
+	for _, name := range staleASGs {
+		fmt.Printf("Reusing previous score for ASG %s: %d\n", name, previousScores[name])
+		scores[name] = previousScores[name]
+	}
+	if hysteresisValue != "" {
+		applyHysteresis(scores)
+	}
+	if rolloutMaxChanges > 0 {
+		scores = stepScores(scores)
+	}
+	logScoreChanges(scores)
+
+	// with TOP_N only the best ranked ASGs make it into the document
+	listed := make(map[string]bool, len(scores))
+	for i, name := range rankASGs(scores) {
+		if topN > 0 && i >= topN {
 			if debug {
-				fmt.Printf("%s/%s has %d free IPs\n", *asg.AutoScalingGroupName, ltName, freeIPs)
+				fmt.Printf("DEBUG: %s is outside TOP_N, not listed\n", name)
 			}
+			continue
 		}
+		listed[name] = true
 	}
 
-	// Initialize Kubernetes client
-	config, err := clientcmd.BuildConfigFromFlags("", "")
-	if err != nil {
-		fmt.Printf("Unable to load kube config: %v\n", err)
-		return
+	listedNames := listedDuplicateNames(matchedASGs, scores, status)
+	for _, asg := range matchedASGs {
+		score := scores[asg.key()]
+		if listed[asg.key()] && listedNames[asg.Name] == asg.key() {
+			caPriorities[score] = append(caPriorities[score], asg.Name)
+		}
+		prioritySamples = append(prioritySamples, metricSample{
+			labels: map[string]string{"asg": asg.key()},
+			value:  float64(score),
+		})
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		fmt.Printf("Unable to create Kubernetes client: %v\n", err)
-		return
+
+	for _, entry := range rejectedImages {
+		status.add("unapprovedImages", entry)
+	}
+	for _, entry := range misroutedSubnets {
+		status.add("misroutedSubnets", entry)
+	}
+	for _, name := range staleASGs {
+		status.add("staleScores", name+": subnet lookup failed, using the score from the previous run")
+	}
+
+	return &priorityPlan{
+		matched:         matchedASGs,
+		excluded:        excludedASGs,
+		scores:          scores,
+		caPriorities:    caPriorities,
+		status:          status,
+		freeIPSamples:   freeIPSamples,
+		subnetSamples:   subnetSamples,
+		prioritySamples: prioritySamples,
+	}, nil
+}
+
+// writePriorities publishes the metrics of a computed plan, runs the checks
+// reported in the status and writes the priorities document
+func writePriorities(plan *priorityPlan, subnets *subnetInventory, config *rest.Config, clientset kubernetes.Interface) error {
+	status := plan.status
+	metrics.replaceGauge(metricASGFreeIPs, profileLabels(), plan.freeIPSamples)
+	metrics.replaceGauge(metricSubnetFreeIPs, profileLabels(), plan.subnetSamples)
+	metrics.setGauge(metricMatchedASGs, profileLabels(), float64(len(plan.matched)))
+	metrics.replaceGauge(metricASGPriority, profileLabels(), plan.prioritySamples)
+
+	if manageCATags {
+		reconcileCATags(plan.matched, plan.excluded)
+	}
+
+	if syncNodeTags {
+		syncNodeTemplateTags(plan.matched)
+	}
+
+	if auditZero {
+		auditScaleFromZero(clientset, plan.matched, status)
 	}
 
-	// Check if configmap exists
-	configMapExists := false
-	_, err = clientset.CoreV1().ConfigMaps(caNamespace).Get(context.Background(), caPriorityExpander, metav1.GetOptions{})
-	if err == nil {
-		configMapExists = true
+	if subnetLowIPThreshold > 0 {
+		checkLowFreeIPs(clientset, plan.subnetSamples, status)
+	}
+
+	if checkKarpenter {
+		validateKarpenterSubnets(config, clientset, subnets.freeIPs, status)
 	}
 
 	// Save config
 	data := make(map[string]string)
-	priorities := ""
-	keys := make([]int, 0, len(caPriorities))
-	for k := range caPriorities {
-		keys = append(keys, k)
-	}
-	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
 
-	for _, key := range keys {
-		priorities += fmt.Sprintf("%d:\n", key)
-		for _, asg := range caPriorities[key] {
-			priorities += fmt.Sprintf("  - %s\n", asg)
-		}
+	collisions := findNameCollisions(plan.caPriorities, plan.matched)
+	for _, message := range reportNameCollisions(collisions, status) {
+		recordEvent(clientset, v1.EventTypeWarning, "NameCollision", message)
 	}
 
-	if catchAll {
-		priorities += "1:\n"
-		priorities += "  - .*\n"
+	priorities, err := renderPriorities(plan.caPriorities, collisions)
+	if err != nil {
+		fmt.Printf("Invalid priorities: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "validation"}, 1)
+		return newRunError(exitValidationError, err)
 	}
 
 	data["priorities"] = priorities
+	if len(status) > 0 {
+		data[statusKey] = status.render()
+	}
 
 	if debug {
 		fmt.Println(data["priorities"])
 	}
 
-	if !configMapExists {
+	if deferDuringScaling && deferPriorityUpdate(clientset, plan.matched) {
+		return nil
+	}
+
+	if outputFormat != outputConfigMap {
+		if err := writeOutput(data); err != nil {
+			fmt.Printf("Error writing %s output: %v\n", outputFormat, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "output"}, 1)
+			return newRunError(exitGenericError, err)
+		}
+		commitScores(plan.scores)
+		return nil
+	}
+
+	if err := writeConfigMap(clientset, caNamespace, caPriorityExpander, data); err != nil {
+		return newRunError(exitKubernetesError, err)
+	}
+
+	// keep the previous location up to date while consumers move over
+	if migrationActive() {
+		if err := writeConfigMap(clientset, migrateFromNamespace, migrateFromName, data); err != nil {
+			return newRunError(exitKubernetesError, err)
+		}
+	}
+
+	commitScores(plan.scores)
+	return nil
+}
+
+// writeConfigMap creates or updates the given configmap with data
+func writeConfigMap(clientset kubernetes.Interface, namespace, name string, data map[string]string) error {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
 		if skipCMCreation {
-			fmt.Printf("Skipping creation of configmap: %s/%s\n", caNamespace, caPriorityExpander)
-		} else {
-			_, err := clientset.CoreV1().ConfigMaps(caNamespace).Create(context.Background(), &v1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: caPriorityExpander,
-				},
-				Data: data,
-			}, metav1.CreateOptions{})
-			if err != nil {
-				fmt.Printf("Error creating configmap: %v\n", err)
-			} else {
-				fmt.Printf("Created configmap: %s/%s\n", caNamespace, caPriorityExpander)
-			}
+			fmt.Printf("Skipping creation of configmap: %s/%s\n", namespace, name)
+			return nil
 		}
-	} else {
-		cm, err := clientset.CoreV1().ConfigMaps(caNamespace).Get(context.Background(), caPriorityExpander, metav1.GetOptions{})
-		if err != nil {
-			fmt.Printf("Error retrieving configmap: %v\n", err)
-			return
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Data: data,
 		}
-		cm.Data = data
-		_, err = clientset.CoreV1().ConfigMaps(caNamespace).Update(context.Background(), cm, metav1.UpdateOptions{})
+		stampGitOpsMetadata(&cm.ObjectMeta)
+		writeStart := time.Now()
+		_, err := clientset.CoreV1().ConfigMaps(namespace).Create(context.Background(), cm, metav1.CreateOptions{})
+		metrics.observe(metricWriteDuration, map[string]string{"operation": "create"}, time.Since(writeStart).Seconds())
 		if err != nil {
-			fmt.Printf("Error updating configmap: %v\n", err)
-			return
+			fmt.Printf("Error creating configmap: %v\n", err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "configmap"}, 1)
+			return err
 		}
-		fmt.Printf("Updated configmap: %s/%s\n", caNamespace, caPriorityExpander)
+		fmt.Printf("Created configmap: %s/%s\n", namespace, name)
+		metrics.incCounter(metricConfigMapWrites, nil, 1)
+		return nil
 	}
 
+	// the data is rendered deterministically, so identical data means nothing
+	// changed and the write can be skipped
+	stamped := cm.ObjectMeta.DeepCopy()
+	stampGitOpsMetadata(stamped)
+	if reflect.DeepEqual(cm.Data, data) && reflect.DeepEqual(cm.ObjectMeta, *stamped) {
+		if debug {
+			fmt.Printf("DEBUG: configmap %s/%s is up to date\n", namespace, name)
+		}
+		return nil
+	}
+
+	cm.Data = data
+	cm.ObjectMeta = *stamped
+	writeStart := time.Now()
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Update(context.Background(), cm, metav1.UpdateOptions{})
+	metrics.observe(metricWriteDuration, map[string]string{"operation": "update"}, time.Since(writeStart).Seconds())
+	if err != nil {
+		fmt.Printf("Error updating configmap: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "configmap"}, 1)
+		return err
+	}
+	fmt.Printf("Updated configmap: %s/%s\n", namespace, name)
+	metrics.incCounter(metricConfigMapWrites, nil, 1)
+	return nil
 }
 
-func awsSearchEC2ASGByName(name string) []*autoscaling.Group {
-	var records []*autoscaling.Group
+// awsSearchEC2ASGs returns the ASGs selected by name. Pages are processed as
+// they arrive and only the fields we need are kept from each group
+func awsSearchEC2ASGs() ([]*asgInfo, error) {
+	if eksNodegroups {
+		return awsSearchEKSNodegroupASGs()
+	}
+
+	var records []*asgInfo
 
-	err := autoscalingClient.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{},
-		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
-			for _, group := range page.AutoScalingGroups {
-				if strings.Contains(*group.AutoScalingGroupName, name) {
-					records = append(records, group)
+	// the ASGs of every region in REGIONS and every account reached through
+	// ASSUME_ROLE_ARNS are merged before scoring
+	for _, clients := range regionClients {
+		err := clients.autoscaling.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{Filters: asgDiscoveryFilters()},
+			func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				for _, group := range page.AutoScalingGroups {
+					if !asgNameMatches(aws.StringValue(group.AutoScalingGroupName)) {
+						continue
+					}
+					if asg := newASGInfo(group, clients); asgTagsMatch(asg.Tags) && asgAutoDiscovered(asg.Tags) {
+						records = append(records, asg)
+					}
 				}
-			}
-			return !lastPage
-		})
+				return !lastPage
+			})
+		if err != nil {
+			fmt.Printf("Error searching EC2 ASGs in %s: %v\n", clients, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+			return records, err
+		}
+	}
+	qualifyDuplicateNames(records)
+	return records, nil
+}
+
+// subnetBatchSize bounds the subnets described by a single DescribeSubnets
+// call
+const subnetBatchSize = 200
+
+// cachedSubnet is a subnet described by an earlier run
+type cachedSubnet struct {
+	subnet    *ec2.Subnet
+	freeIPs   int
+	ec2       *ec2.EC2
+	described time.Time
+	// free /28 prefixes, -1 until PREFIX_DELEGATION counts them
+	freePrefixes int
+}
+
+// subnetCache keeps the subnets described across runs for SUBNET_CACHE_TTL
+var subnetCache = make(map[string]cachedSubnet)
+
+// subnetInventory caches the subnets described during a run
+type subnetInventory struct {
+	subnets map[string]*ec2.Subnet
+	// free IPv4 addresses of each subnet
+	freeIPs map[string]int
+	// free /28 prefixes of the subnets counted for PREFIX_DELEGATION
+	prefixes map[string]int
+	// why each subnet checked by VALIDATE_SUBNET_ROUTES is misrouted, empty
+	// when it isn't
+	routes map[string]string
+	// client of the region each subnet was described in
+	ec2 map[string]*ec2.EC2
+	// explicit CIDR reservations of each subnet, see cidrReservations
+	reservations map[string][]*net.IPNet
+	// time spent describing subnets, reported as part of discovery
+	elapsed time.Duration
+}
+
+// newSubnetInventory returns the inventory for a run, seeded with the
+// subnets described less than SUBNET_CACHE_TTL ago. Stale entries are
+// dropped and described again when an ASG needs them, and
+// SUBNET_CACHE_REFRESH drops every entry
+func newSubnetInventory() *subnetInventory {
+	s := &subnetInventory{
+		subnets:      make(map[string]*ec2.Subnet),
+		freeIPs:      make(map[string]int),
+		prefixes:     make(map[string]int),
+		routes:       make(map[string]string),
+		ec2:          make(map[string]*ec2.EC2),
+		reservations: make(map[string][]*net.IPNet),
+	}
+	for subnetID, cached := range subnetCache {
+		if subnetCacheRefresh || time.Since(cached.described) >= subnetCacheTTL {
+			delete(subnetCache, subnetID)
+			continue
+		}
+		s.subnets[subnetID] = cached.subnet
+		s.freeIPs[subnetID] = cached.freeIPs
+		if cached.freePrefixes >= 0 {
+			s.prefixes[subnetID] = cached.freePrefixes
+		}
+		s.ec2[subnetID] = cached.ec2
+	}
+	return s
+}
+
+// lookup returns the free IPs of one of the ASG's subnets, as counted for
+// IP_FAMILY, describing it on first use. With PREFIX_DELEGATION the IPv4
+// addresses pods can get are those of the free /28 prefixes
+func (s *subnetInventory) lookup(asg *asgInfo, subnetID string) (int, error) {
+	if _, ok := s.subnets[subnetID]; !ok {
+		if err := s.describe(asg, subnetID); err != nil {
+			return 0, err
+		}
+	}
+	freeIPs := s.freeIPs[subnetID]
+	if prefixDelegation && ipFamily == ipFamilyIPv4 {
+		prefixes, err := s.freePrefixes(subnetID)
+		if err != nil {
+			return 0, err
+		}
+		freeIPs = prefixes * ipv4PrefixSize
+	}
+	return subnetFreeIPs(s.subnets[subnetID], freeIPs), nil
+}
+
+// freePrefixes returns the free /28 prefixes of a described subnet, counting
+// them on first use
+func (s *subnetInventory) freePrefixes(subnetID string) (int, error) {
+	if prefixes, ok := s.prefixes[subnetID]; ok {
+		return prefixes, nil
+	}
+
+	start := time.Now()
+	defer func() { s.elapsed += time.Since(start) }()
+	reservations, err := s.cidrReservations(s.ec2[subnetID], subnetID)
 	if err != nil {
-		fmt.Printf("Error searching EC2 ASGs by name: %v\n", err)
+		return 0, err
+	}
+	prefixes, err := subnetFreePrefixes(s.ec2[subnetID], s.subnets[subnetID], reservations)
+	if err != nil {
+		return 0, err
+	}
+	if debug {
+		fmt.Printf("DEBUG: subnet %s has %d free /28 prefixes\n", subnetID, prefixes)
+	}
+	s.prefixes[subnetID] = prefixes
+	if cached, ok := subnetCache[subnetID]; ok {
+		cached.freePrefixes = prefixes
+		subnetCache[subnetID] = cached
+	}
+	return prefixes, nil
+}
+
+// describe describes one of the ASG's subnets and records it. Subnets the
+// ASG's account can't describe are looked up through SHARED_SUBNET_ROLE_ARNS
+func (s *subnetInventory) describe(asg *asgInfo, subnetID string) error {
+	start := time.Now()
+	defer func() { s.elapsed += time.Since(start) }()
+	clients := asg.api()
+	subnet, err := clients.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String(subnetID)},
+	})
+	if err == nil && len(subnet.Subnets) == 0 {
+		err = fmt.Errorf("subnet %s not found", subnetID)
+	}
+	if err != nil {
+		if len(sharedSubnetRoleARNs) == 0 {
+			return err
+		}
+		shared, owner, sharedErr := describeSharedSubnet("", clients.region, subnetID)
+		if sharedErr != nil {
+			return err
+		}
+		s.record(owner, shared)
+		return nil
+	}
+	s.record(clients, subnet.Subnets[0])
+	return nil
+}
+
+// record caches a described subnet for the run, and for the next ones when
+// SUBNET_CACHE_TTL is set. Its free IPs leave out the explicit CIDR
+// reservations. Subnets shared with the account of the clients are
+// described again as their owner when SHARED_SUBNET_ROLE_ARNS has a role
+// for it
+func (s *subnetInventory) record(clients *awsClients, subnet *ec2.Subnet) {
+	subnetID := aws.StringValue(subnet.SubnetId)
+	if owner := aws.StringValue(subnet.OwnerId); sharedSubnetOwned(clients, owner) {
+		shared, ownerClients, err := describeSharedSubnet(owner, clients.region, subnetID)
+		if err != nil {
+			fmt.Printf("Unable to describe shared subnet %s as its owner %s: %v\n", subnetID, owner, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		} else {
+			subnet, clients = shared, ownerClients
+		}
+	}
+	client := clients.ec2
+
+	freeIPs := int(aws.Int64Value(subnet.AvailableIpAddressCount))
+	reservations, err := s.cidrReservations(client, subnetID)
+	if err != nil {
+		fmt.Printf("Unable to get the CIDR reservations of subnet %s, counting its free IPs in full: %v\n", subnetID, err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+	} else if reserved := reservedIPs(reservations); reserved > 0 {
+		if debug {
+			fmt.Printf("DEBUG: subnet %s has %d reserved IPs\n", subnetID, reserved)
+		}
+		freeIPs -= reserved
+		if freeIPs < 0 {
+			freeIPs = 0
+		}
+	}
+	s.subnets[subnetID] = subnet
+	s.freeIPs[subnetID] = freeIPs
+	s.ec2[subnetID] = client
+	if subnetCacheTTL > 0 {
+		subnetCache[subnetID] = cachedSubnet{subnet: subnet, freeIPs: freeIPs, ec2: client, described: time.Now(), freePrefixes: -1}
+	}
+}
+
+// prefetch describes the subnets of every ASG up front, batching them per
+// account and region so each one is described once with as few calls as
+// possible. Subnets of failed batches, e.g. with a subnet that no longer
+// exists, are left to lookup
+func (s *subnetInventory) prefetch(asgs []*asgInfo) {
+	pending := make(map[*awsClients][]*string)
+	queued := make(map[string]bool)
+	for _, asg := range asgs {
+		for _, subnetID := range asg.subnetIDs {
+			if _, ok := s.subnets[subnetID]; ok || queued[subnetID] {
+				continue
+			}
+			queued[subnetID] = true
+			pending[asg.api()] = append(pending[asg.api()], aws.String(subnetID))
+		}
+	}
+
+	start := time.Now()
+	defer func() { s.elapsed += time.Since(start) }()
+	for clients, subnetIDs := range pending {
+		for first := 0; first < len(subnetIDs); first += subnetBatchSize {
+			last := first + subnetBatchSize
+			if last > len(subnetIDs) {
+				last = len(subnetIDs)
+			}
+			err := clients.ec2.DescribeSubnetsPages(&ec2.DescribeSubnetsInput{SubnetIds: subnetIDs[first:last]},
+				func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+					for _, subnet := range page.Subnets {
+						s.record(clients, subnet)
+					}
+					return !lastPage
+				})
+			if err != nil && debug {
+				fmt.Printf("DEBUG: unable to describe subnets in a batch in %s, describing them one by one: %v\n", clients, err)
+			}
+		}
 	}
-	return records
 }
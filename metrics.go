@@ -0,0 +1,229 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	gaugeMetric   = "gauge"
+	counterMetric = "counter"
+)
+
+// metricSample is a single labelled value of a metric
+type metricSample struct {
+	name   string
+	kind   string
+	labels map[string]string
+	value  float64
+}
+
+// histogram keeps cumulative bucket counts of observed values
+type histogram struct {
+	name    string
+	labels  map[string]string
+	buckets []float64
+	counts  []float64
+	sum     float64
+	count   float64
+}
+
+// durationBuckets are the upper bounds, in seconds, used for every
+// duration histogram
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metricsRegistry keeps the latest value of every gauge, counter and
+// histogram so the different exporters can publish them
+type metricsRegistry struct {
+	mu         sync.Mutex
+	gauges     map[string]metricSample
+	histograms map[string]*histogram
+}
+
+var metrics = &metricsRegistry{
+	gauges:     make(map[string]metricSample),
+	histograms: make(map[string]*histogram),
+}
+
+// seriesKey identifies a metric name plus label set
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := name
+	for _, key := range keys {
+		series += "," + key + "=" + labels[key]
+	}
+	return series
+}
+
+func (r *metricsRegistry) setGauge(name string, labels map[string]string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[seriesKey(name, labels)] = metricSample{name: name, kind: gaugeMetric, labels: labels, value: value}
+}
+
+// incCounter adds delta to the given counter
+func (r *metricsRegistry) incCounter(name string, labels map[string]string, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := seriesKey(name, labels)
+	sample := r.gauges[key]
+	r.gauges[key] = metricSample{name: name, kind: counterMetric, labels: labels, value: sample.value + delta}
+}
+
+// observe records a value in the given histogram
+func (r *metricsRegistry) observe(name string, labels map[string]string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := seriesKey(name, labels)
+	h, ok := r.histograms[key]
+	if !ok {
+		h = &histogram{name: name, labels: labels, buckets: durationBuckets, counts: make([]float64, len(durationBuckets))}
+		r.histograms[key] = h
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// replaceGauge atomically swaps every series of the given metric carrying
+// the scope labels for the new set, so groups that are no longer matched stop
+// being reported. The scope labels are added to every new sample
+func (r *metricsRegistry) replaceGauge(name string, scope map[string]string, samples []metricSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, sample := range r.gauges {
+		if sample.name == name && hasLabels(sample.labels, scope) {
+			delete(r.gauges, key)
+		}
+	}
+	for _, sample := range samples {
+		for key, value := range scope {
+			sample.labels = withLabel(sample.labels, key, value)
+		}
+		sample.name = name
+		sample.kind = gaugeMetric
+		r.gauges[seriesKey(name, sample.labels)] = sample
+	}
+}
+
+// hasLabels reports whether labels contains every label of subset
+func hasLabels(labels, subset map[string]string) bool {
+	for key, value := range subset {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// samples returns the current value of every series of the given metric, or
+// of all metrics if name is empty
+func (r *metricsRegistry) samples(name string) []metricSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var samples []metricSample
+	for _, sample := range r.gauges {
+		if name == "" || sample.name == name {
+			samples = append(samples, sample)
+		}
+	}
+
+	// histograms are flattened the Prometheus way: one cumulative counter per
+	// bucket plus their sum and count
+	for _, h := range r.histograms {
+		if name != "" && h.name != name {
+			continue
+		}
+		for i, bound := range h.buckets {
+			samples = append(samples, metricSample{
+				name:   h.name + "_bucket",
+				kind:   counterMetric,
+				labels: withLabel(h.labels, "le", strconv.FormatFloat(bound, 'f', -1, 64)),
+				value:  h.counts[i],
+			})
+		}
+		samples = append(samples,
+			metricSample{name: h.name + "_bucket", kind: counterMetric, labels: withLabel(h.labels, "le", "+Inf"), value: h.count},
+			metricSample{name: h.name + "_sum", kind: counterMetric, labels: h.labels, value: h.sum},
+			metricSample{name: h.name + "_count", kind: counterMetric, labels: h.labels, value: h.count},
+		)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return seriesKey(samples[i].name, samples[i].labels) < seriesKey(samples[j].name, samples[j].labels)
+	})
+	return samples
+}
+
+// withLabel returns a copy of labels with the extra label set
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	copied := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+const (
+	metricASGFreeIPs      = "ca_autoconfig_asg_free_ips"
+	metricSubnetFreeIPs   = "ca_autoconfig_subnet_free_ips"
+	metricASGPriority     = "ca_autoconfig_asg_priority"
+	metricMatchedASGs     = "ca_autoconfig_matched_asgs"
+	metricRunsTotal       = "ca_autoconfig_runs_total"
+	metricErrorsTotal     = "ca_autoconfig_errors_total"
+	metricLastRunTime     = "ca_autoconfig_last_run_timestamp_seconds"
+	metricConfigMapWrites = "ca_autoconfig_configmap_writes_total"
+
+	metricReconcileDuration = "ca_autoconfig_reconcile_duration_seconds"
+	metricDiscoveryDuration = "ca_autoconfig_aws_discovery_duration_seconds"
+	metricWriteDuration     = "ca_autoconfig_configmap_write_duration_seconds"
+	metricReconcileTotal    = "ca_autoconfig_reconcile_total"
+
+	metricShadowDivergence = "ca_autoconfig_shadow_divergence_ratio"
+	metricShadowMovedASGs  = "ca_autoconfig_shadow_moved_asgs"
+	metricShadowTopMatch   = "ca_autoconfig_shadow_top_match"
+
+	metricLowFreeIPSubnets = "ca_autoconfig_low_free_ip_subnets"
+
+	metricSubnetExhaustionSeconds = "ca_autoconfig_subnet_exhaustion_forecast_seconds"
+)
+
+// reconcileOutcomes labels the reconcile counter by exit code class
+var reconcileOutcomes = map[int]string{
+	exitOK:                "success",
+	exitGenericError:      "error",
+	exitConfigError:       "config_error",
+	exitAWSDiscoveryError: "aws_error",
+	exitKubernetesError:   "kubernetes_error",
+	exitValidationError:   "validation_error",
+}
+
+// publishMetrics pushes the current metrics to every enabled exporter
+func publishMetrics() {
+	if datadogMetrics {
+		publishDogStatsD()
+	}
+	if statsdAddr != "" {
+		publishStatsD()
+	}
+	if metricsTextfile != "" {
+		writeMetricsTextfile()
+	}
+	if otlpEndpoint != "" {
+		publishOTLP()
+	}
+	if cloudWatchPublish {
+		publishCloudWatch()
+	}
+}
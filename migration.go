@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MIGRATE_FROM names the configmap the priorities used to be written to, as
+// namespace/name or just name in CA_NAMESPACE. Until MIGRATE_UNTIL (RFC 3339)
+// it keeps being written alongside the new one so the cluster-autoscaler can
+// be moved over without a gap
+
+// whether the end of the migration window has already been logged
+var migrationEndLogged bool
+
+// migrationActive reports whether the previous configmap still has to be
+// written
+func migrationActive() bool {
+	if migrateFromName == "" {
+		return false
+	}
+	if time.Now().After(migrateUntil) {
+		if !migrationEndLogged {
+			fmt.Printf("Migration window ended at %s, no longer writing %s/%s\n", migrateUntil.Format(time.RFC3339), migrateFromNamespace, migrateFromName)
+			migrationEndLogged = true
+		}
+		return false
+	}
+	return true
+}
+
+// parseMigrateFrom splits MIGRATE_FROM into namespace and name
+func parseMigrateFrom(value string) (string, string) {
+	if value == "" {
+		return "", ""
+	}
+	if parts := strings.SplitN(value, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return caNamespace, value
+}
+
+// validateMigration checks the migration settings
+func validateMigration() error {
+	if migrateFromName == "" {
+		return nil
+	}
+	if migrateUntil.IsZero() {
+		return fmt.Errorf("MIGRATE_UNTIL must be an RFC 3339 time when MIGRATE_FROM is set, e.g. 2024-01-31T00:00:00Z")
+	}
+	if migrateFromNamespace == caNamespace && migrateFromName == caPriorityExpander {
+		return fmt.Errorf("MIGRATE_FROM is the current configmap %s/%s", caNamespace, caPriorityExpander)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// MIN_FREE_IPS_ACTION values
+const (
+	minFreeIPsDemote  = "demote"
+	minFreeIPsExclude = "exclude"
+)
+
+// validMinFreeIPsAction reports whether MIN_FREE_IPS_ACTION is supported
+func validMinFreeIPsAction() bool {
+	return minFreeIPsAction == minFreeIPsDemote || minFreeIPsAction == minFreeIPsExclude
+}
+
+// belowMinFreeIPs returns why the ASG is too short of free IPs for a
+// scale-up to succeed, or an empty string when it has MIN_FREE_IPS. The
+// reason leaves the count out so the status doesn't change on every run
+func belowMinFreeIPs(asg *asgInfo) string {
+	if asg.FreeIPs >= minFreeIPs {
+		return ""
+	}
+	return fmt.Sprintf("free IPs below MIN_FREE_IPS %d", minFreeIPs)
+}
+
+// excludeBelowMinFreeIPs returns the measured ASGs short of MIN_FREE_IPS,
+// which are left out of the document entirely
+func excludeBelowMinFreeIPs(measured []*asgInfo, status statusReport) map[*asgInfo]bool {
+	excluded := make(map[*asgInfo]bool)
+	for _, asg := range measured {
+		if reason := belowMinFreeIPs(asg); reason != "" {
+			fmt.Printf("Excluding ASG %s: %s (%d)\n", asg.Name, reason, asg.FreeIPs)
+			status.add("belowMinFreeIPs", asg.key()+": "+reason)
+			excluded[asg] = true
+		}
+	}
+	return excluded
+}
+
+// withoutASGs returns the ASGs not in the set
+func withoutASGs(asgs []*asgInfo, set map[*asgInfo]bool) []*asgInfo {
+	var kept []*asgInfo
+	for _, asg := range asgs {
+		if !set[asg] {
+			kept = append(kept, asg)
+		}
+	}
+	return kept
+}
+
+// applyMinFreeIPsDemotion demotes the ASGs short of MIN_FREE_IPS, since
+// scale-ups there are about to fail for lack of addresses
+func applyMinFreeIPsDemotion(measured []*asgInfo, scores map[string]int, status statusReport) {
+	for _, asg := range measured {
+		if reason := belowMinFreeIPs(asg); reason != "" {
+			demoteASG(scores, status, asg.key(), reason)
+		}
+	}
+}
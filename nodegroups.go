@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+// DescribeAutoScalingGroups accepts at most this many names per call
+const describeASGNamesLimit = 50
+
+// nodegroupSelected reports whether a managed node group is selected by
+// NODEGROUP_CONTAINS and NODEGROUP_LABEL_SELECTOR
+func nodegroupSelected(nodegroup *eks.Nodegroup) bool {
+	if !containsAny(aws.StringValue(nodegroup.NodegroupName), nodegroupContains) {
+		return false
+	}
+	labels := aws.StringValueMap(nodegroup.Labels)
+	for _, requirement := range nodegroupLabelSelector {
+		value, ok := labels[requirement.key]
+		if !ok || (!requirement.anyValue && value != requirement.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// awsSearchEKSNodegroupASGs lists the managed node groups of the cluster and
+// returns the ASGs backing the selected ones
+func awsSearchEKSNodegroupASGs() ([]*asgInfo, error) {
+	var names []string
+	err := eksClient.ListNodegroupsPages(&eks.ListNodegroupsInput{ClusterName: aws.String(clusterName)},
+		func(page *eks.ListNodegroupsOutput, lastPage bool) bool {
+			names = append(names, aws.StringValueSlice(page.Nodegroups)...)
+			return !lastPage
+		})
+	if err != nil {
+		fmt.Printf("Error listing EKS node groups of %s: %v\n", clusterName, err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		return nil, err
+	}
+
+	nodegroupOf := make(map[string]string)
+	var asgNames []*string
+	for _, name := range names {
+		output, err := eksClient.DescribeNodegroup(&eks.DescribeNodegroupInput{
+			ClusterName:   aws.String(clusterName),
+			NodegroupName: aws.String(name),
+		})
+		if err != nil {
+			fmt.Printf("Error describing EKS node group %s: %v\n", name, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+			return nil, err
+		}
+		if !nodegroupSelected(output.Nodegroup) {
+			if debug {
+				fmt.Printf("DEBUG: skipping EKS node group %s\n", name)
+			}
+			continue
+		}
+		if output.Nodegroup.Resources == nil {
+			continue
+		}
+		for _, group := range output.Nodegroup.Resources.AutoScalingGroups {
+			nodegroupOf[aws.StringValue(group.Name)] = name
+			asgNames = append(asgNames, group.Name)
+		}
+	}
+
+	var records []*asgInfo
+	for start := 0; start < len(asgNames); start += describeASGNamesLimit {
+		end := start + describeASGNamesLimit
+		if end > len(asgNames) {
+			end = len(asgNames)
+		}
+		err := autoscalingClient.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: asgNames[start:end]},
+			func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				for _, group := range page.AutoScalingGroups {
+					asg := newASGInfo(group, homeClients)
+					asg.Nodegroup = nodegroupOf[asg.Name]
+					records = append(records, asg)
+				}
+				return !lastPage
+			})
+		if err != nil {
+			fmt.Printf("Error searching EC2 ASGs: %v\n", err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+			return nil, err
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const nodeTemplateTagPrefix = "k8s.io/cluster-autoscaler/node-template/"
+
+// eksTaintEffects maps EKS API taint effects to their Kubernetes names
+var eksTaintEffects = map[string]string{
+	"NO_SCHEDULE":        "NoSchedule",
+	"NO_EXECUTE":         "NoExecute",
+	"PREFER_NO_SCHEDULE": "PreferNoSchedule",
+}
+
+// describeLaunchConfigurationData returns the image and instance type of the
+// ASG's launch configuration in the shape of launch template data
+func describeLaunchConfigurationData(asg *asgInfo) (*ec2.ResponseLaunchTemplateData, error) {
+	output, err := asg.api().autoscaling.DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(asg.LaunchConfiguration)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.LaunchConfigurations) == 0 {
+		return nil, fmt.Errorf("launch configuration %s not found", asg.LaunchConfiguration)
+	}
+	return &ec2.ResponseLaunchTemplateData{
+		ImageId:      output.LaunchConfigurations[0].ImageId,
+		InstanceType: output.LaunchConfigurations[0].InstanceType,
+	}, nil
+}
+
+// describeLaunchTemplateData resolves the launch template version used by
+// the ASG ($Default unless pinned, $Latest and $Default are resolved to the
+// version they point to) and returns its data. ASGs using a launch
+// configuration get its image and instance type instead. The data is kept
+// on the ASG for the rest of the run
+func describeLaunchTemplateData(asg *asgInfo) (*ec2.ResponseLaunchTemplateData, error) {
+	if asg.launchData != nil {
+		return asg.launchData, nil
+	}
+
+	var data *ec2.ResponseLaunchTemplateData
+	var err error
+	if asg.launchTemplateSpec == nil {
+		data, err = describeLaunchConfigurationData(asg)
+	} else {
+		data, err = describeLaunchTemplateVersion(asg, asg.launchTemplateSpec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	asg.launchData = data
+	return data, nil
+}
+
+// describeLaunchTemplateVersion returns the data of a launch template
+// version used by the ASG
+func describeLaunchTemplateVersion(asg *asgInfo, spec *autoscaling.LaunchTemplateSpecification) (*ec2.ResponseLaunchTemplateData, error) {
+	version := aws.StringValue(spec.Version)
+	if version == "" {
+		version = "$Default"
+	}
+
+	input := &ec2.DescribeLaunchTemplateVersionsInput{
+		Versions: []*string{aws.String(version)},
+	}
+	if spec.LaunchTemplateId != nil {
+		input.LaunchTemplateId = spec.LaunchTemplateId
+	} else {
+		input.LaunchTemplateName = spec.LaunchTemplateName
+	}
+
+	output, err := asg.api().ec2.DescribeLaunchTemplateVersions(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(output.LaunchTemplateVersions) == 0 {
+		return nil, fmt.Errorf("launch template version %s not found", version)
+	}
+	if debug {
+		fmt.Printf("DEBUG: %s uses version %d (%s) of launch template %s\n", asg.Name, aws.Int64Value(output.LaunchTemplateVersions[0].VersionNumber), version, aws.StringValue(output.LaunchTemplateVersions[0].LaunchTemplateName))
+	}
+	return output.LaunchTemplateVersions[0].LaunchTemplateData, nil
+}
+
+// asgInstanceType returns the instance type CA will use to build the node
+// template: the launch template's own type or the first MixedInstancesPolicy
+// override
+func asgInstanceType(asg *asgInfo, data *ec2.ResponseLaunchTemplateData) string {
+	if data != nil && data.InstanceType != nil {
+		return *data.InstanceType
+	}
+	if len(asg.overrideInstanceTypes) > 0 {
+		return asg.overrideInstanceTypes[0]
+	}
+	return ""
+}
+
+// nodeTemplateTags derives the node-template tags CA needs to scale the ASG
+// from zero
+func nodeTemplateTags(asg *asgInfo, instanceTypes map[string]*ec2.InstanceTypeInfo) (map[string]string, error) {
+	tags := make(map[string]string)
+
+	var data *ec2.ResponseLaunchTemplateData
+	if asg.hasLaunchData() {
+		var err error
+		data, err = describeLaunchTemplateData(asg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if instanceType := asgInstanceType(asg, data); instanceType != "" {
+		info, err := describeInstanceType(asg, instanceType, instanceTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		if info != nil {
+			if info.VCpuInfo != nil {
+				tags[nodeTemplateTagPrefix+"resources/cpu"] = fmt.Sprintf("%d", aws.Int64Value(info.VCpuInfo.DefaultVCpus))
+			}
+			if info.MemoryInfo != nil {
+				tags[nodeTemplateTagPrefix+"resources/memory"] = fmt.Sprintf("%dMi", aws.Int64Value(info.MemoryInfo.SizeInMiB))
+			}
+			if info.GpuInfo != nil {
+				gpus := int64(0)
+				for _, gpu := range info.GpuInfo.Gpus {
+					if strings.EqualFold(aws.StringValue(gpu.Manufacturer), "NVIDIA") {
+						gpus += aws.Int64Value(gpu.Count)
+					}
+				}
+				if gpus > 0 {
+					tags[nodeTemplateTagPrefix+"resources/nvidia.com/gpu"] = fmt.Sprintf("%d", gpus)
+				}
+			}
+		}
+	}
+
+	// EKS managed node groups carry their labels and taints in the EKS API
+	// rather than in the launch template
+	eksCluster, isEKSCluster := asgTagValue(asg, "eks:cluster-name")
+	eksNodegroup, isEKSNodegroup := asgTagValue(asg, "eks:nodegroup-name")
+	if isEKSCluster && isEKSNodegroup {
+		output, err := asg.api().eks.DescribeNodegroup(&eks.DescribeNodegroupInput{
+			ClusterName:   aws.String(eksCluster),
+			NodegroupName: aws.String(eksNodegroup),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range output.Nodegroup.Labels {
+			tags[nodeTemplateTagPrefix+"label/"+key] = aws.StringValue(value)
+		}
+		for _, taint := range output.Nodegroup.Taints {
+			effect := eksTaintEffects[aws.StringValue(taint.Effect)]
+			tags[nodeTemplateTagPrefix+"taint/"+aws.StringValue(taint.Key)] = aws.StringValue(taint.Value) + ":" + effect
+		}
+	}
+
+	return tags, nil
+}
+
+// syncNodeTemplateTags applies the derived node-template tags to every
+// matched ASG
+func syncNodeTemplateTags(matched []*asgInfo) {
+	instanceTypes := make(map[string]*ec2.InstanceTypeInfo)
+
+	for _, asg := range matched {
+		tags, err := nodeTemplateTags(asg, instanceTypes)
+		if err != nil {
+			fmt.Printf("Error deriving node-template tags for ASG %s: %v\n", asg.Name, err)
+			continue
+		}
+		if debug {
+			fmt.Printf("DEBUG: node-template tags for %s: %v\n", asg.Name, tags)
+		}
+
+		changed, err := ensureASGTags(asg, tags)
+		if err != nil {
+			fmt.Printf("Error applying node-template tags to ASG %s: %v\n", asg.Name, err)
+		} else if changed {
+			fmt.Printf("Updated node-template tags on ASG: %s\n", asg.Name)
+		}
+	}
+}
+
+// auditScaleFromZero reports the matched ASGs with MinSize 0 that lack some
+// of the node-template tags CA needs to simulate a node for them
+func auditScaleFromZero(clientset kubernetes.Interface, matched []*asgInfo, status statusReport) {
+	instanceTypes := make(map[string]*ec2.InstanceTypeInfo)
+
+	for _, asg := range matched {
+		if asg.MinSize != 0 {
+			continue
+		}
+
+		expected, err := nodeTemplateTags(asg, instanceTypes)
+		if err != nil {
+			fmt.Printf("Error deriving node-template tags for ASG %s: %v\n", asg.Name, err)
+			continue
+		}
+
+		var missing []string
+		for key := range expected {
+			if _, ok := asgTagValue(asg, key); !ok {
+				missing = append(missing, strings.TrimPrefix(key, nodeTemplateTagPrefix))
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		sort.Strings(missing)
+
+		message := fmt.Sprintf("ASG %s has MinSize 0 but is missing node-template tags: %s", asg.Name, strings.Join(missing, ", "))
+		fmt.Println(message)
+		status.add("scaleFromZeroNotReady", asg.Name)
+		recordEvent(clientset, v1.EventTypeWarning, "ScaleFromZeroNotReady", message)
+	}
+}
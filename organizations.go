@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// organizationRoleARNs returns the ARN of the ORGANIZATION_ROLE_NAME role in
+// every active member account of the organization other than our own
+func organizationRoleARNs(sess *session.Session) ([]string, error) {
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+	partition := awsPartition()
+
+	var roles []string
+	err = organizations.New(sess).ListAccountsPages(&organizations.ListAccountsInput{},
+		func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+			for _, account := range page.Accounts {
+				if aws.StringValue(account.Status) != organizations.AccountStatusActive || aws.StringValue(account.Id) == aws.StringValue(identity.Account) {
+					continue
+				}
+				roles = append(roles, fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, aws.StringValue(account.Id), organizationRoleName))
+			}
+			return !lastPage
+		})
+	return roles, err
+}
+
+// discoveryRoles returns the roles assumed to discover ASGs in other
+// accounts: ASSUME_ROLE_ARNS and, with ORGANIZATION_ROLE_NAME, the role of
+// that name in every account of the organization
+func discoveryRoles(sess *session.Session) []string {
+	roles := append([]string(nil), assumeRoleARNs...)
+	if organizationRoleName == "" {
+		return roles
+	}
+	seen := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		seen[role] = true
+	}
+
+	accountRoles, err := organizationRoleARNs(sess)
+	if err != nil {
+		fmt.Printf("Unable to list the organization accounts, only discovering ASGs in the configured ones: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		return roles
+	}
+	for _, role := range accountRoles {
+		if !seen[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// start of the cumulative counters and histograms pushed over OTLP
+var processStart = time.Now()
+
+// OTLP aggregation temporality for values accumulated since processStart
+const otlpCumulative = 2
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Gauge     *otlpGauge     `json:"gauge,omitempty"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+// snapshot returns a copy of every gauge and counter, and of every histogram
+func (r *metricsRegistry) snapshot() ([]metricSample, []histogram) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	samples := make([]metricSample, 0, len(r.gauges))
+	for _, sample := range r.gauges {
+		samples = append(samples, sample)
+	}
+	histograms := make([]histogram, 0, len(r.histograms))
+	for _, h := range r.histograms {
+		copied := *h
+		copied.counts = append([]float64(nil), h.counts...)
+		histograms = append(histograms, copied)
+	}
+	return samples, histograms
+}
+
+// otlpAttributes converts metric labels to OTLP attributes
+func otlpAttributes(labels map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: labels[key]}})
+	}
+	return attributes
+}
+
+// otlpRequest builds the OTLP export request for the current metrics
+func otlpRequest() otlpMetricsRequest {
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := strconv.FormatInt(processStart.UnixNano(), 10)
+	samples, histograms := metrics.snapshot()
+
+	byName := make(map[string]*otlpMetric)
+	var names []string
+	metricFor := func(name string) *otlpMetric {
+		if metric, ok := byName[name]; ok {
+			return metric
+		}
+		byName[name] = &otlpMetric{Name: name}
+		names = append(names, name)
+		return byName[name]
+	}
+
+	for _, sample := range samples {
+		metric := metricFor(sample.name)
+		point := otlpNumberPoint{Attributes: otlpAttributes(sample.labels), TimeUnixNano: now, AsDouble: sample.value}
+		if sample.kind == counterMetric {
+			if metric.Sum == nil {
+				metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			}
+			point.StartTimeUnixNano = start
+			metric.Sum.DataPoints = append(metric.Sum.DataPoints, point)
+			continue
+		}
+		if metric.Gauge == nil {
+			metric.Gauge = &otlpGauge{}
+		}
+		metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, point)
+	}
+
+	for _, h := range histograms {
+		metric := metricFor(h.name)
+		if metric.Histogram == nil {
+			metric.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+		}
+		// our bucket counts are cumulative, OTLP wants them per bucket plus
+		// the overflow bucket
+		buckets := make([]string, 0, len(h.counts)+1)
+		previous := 0.0
+		for _, count := range h.counts {
+			buckets = append(buckets, strconv.FormatFloat(count-previous, 'f', 0, 64))
+			previous = count
+		}
+		buckets = append(buckets, strconv.FormatFloat(h.count-previous, 'f', 0, 64))
+		metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, otlpHistogramPoint{
+			Attributes:        otlpAttributes(h.labels),
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			Count:             strconv.FormatFloat(h.count, 'f', 0, 64),
+			Sum:               h.sum,
+			BucketCounts:      buckets,
+			ExplicitBounds:    h.buckets,
+		})
+	}
+
+	sort.Strings(names)
+	scope := otlpScopeMetrics{}
+	scope.Scope.Name = eventComponent
+	for _, name := range names {
+		scope.Metrics = append(scope.Metrics, *byName[name])
+	}
+
+	resource := otlpResourceMetrics{ScopeMetrics: []otlpScopeMetrics{scope}}
+	resource.Resource.Attributes = []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: otlpServiceName}}}
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{resource}}
+}
+
+// publishOTLP pushes every metric to the OpenTelemetry collector using
+// OTLP/HTTP with the JSON encoding
+func publishOTLP() {
+	payload, err := json.Marshal(otlpRequest())
+	if err != nil {
+		fmt.Printf("Unable to encode OTLP metrics: %v\n", err)
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, otlpEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		fmt.Printf("Unable to send metrics to %s: %v\n", otlpEndpoint, err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for _, header := range splitList(otlpHeaders) {
+		if parts := strings.SplitN(header, "=", 2); len(parts) == 2 {
+			request.Header.Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		fmt.Printf("Unable to send metrics to %s: %v\n", otlpEndpoint, err)
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		fmt.Printf("OTLP collector at %s returned %s\n", otlpEndpoint, response.Status)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// key of the outcomes configmap holding the JSON document
+const outcomesKey = "outcomes"
+
+// weight kept by past outcomes every time a new one is recorded, so
+// chronically unreliable groups drift down and recovered ones drift back up
+const outcomeDecay = 0.9
+
+// how long a launched instance has to become a Ready node before the launch
+// counts as failed
+const outcomeReadyTimeout = 15 * time.Minute
+
+// description of every launch activity, failed launches carry no instance ID
+const launchActivityPrefix = "Launching a new EC2 instance"
+
+// instance launched by a successful launch activity, from its description
+var launchedInstancePattern = regexp.MustCompile(`^Launching a new EC2 instance: (i-[0-9a-f]+)`)
+
+// asgOutcomes is the scale-up history of an ASG
+type asgOutcomes struct {
+	Successes float64 `json:"successes"`
+	Failures  float64 `json:"failures"`
+	// moving average of the seconds from launch to the node being Ready
+	ReadySeconds float64 `json:"readySeconds,omitempty"`
+	// start of the newest activity already counted
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+// successRate returns the share of successful scale-ups, smoothed towards an
+// even chance while the history is short, and false for groups without any
+func (o asgOutcomes) successRate() (float64, bool) {
+	if o.Successes == 0 && o.Failures == 0 {
+		return 0, false
+	}
+	return (o.Successes + 1) / (o.Successes + o.Failures + 2), true
+}
+
+// loadOutcomes reads the outcomes store, returning an empty one if the
+// configmap doesn't exist yet
+func loadOutcomes(clientset kubernetes.Interface) (map[string]*asgOutcomes, *v1.ConfigMap, error) {
+	outcomes := make(map[string]*asgOutcomes)
+	cm, err := clientset.CoreV1().ConfigMaps(caNamespace).Get(context.Background(), outcomesConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return outcomes, nil, nil
+	}
+	if document := cm.Data[outcomesKey]; document != "" {
+		if err := json.Unmarshal([]byte(document), &outcomes); err != nil {
+			return nil, nil, fmt.Errorf("parsing %s/%s: %v", caNamespace, outcomesConfigMap, err)
+		}
+	}
+	return outcomes, cm, nil
+}
+
+// saveOutcomes writes the outcomes store back
+func saveOutcomes(clientset kubernetes.Interface, outcomes map[string]*asgOutcomes, cm *v1.ConfigMap) error {
+	document, err := json.Marshal(outcomes)
+	if err != nil {
+		return err
+	}
+
+	if cm == nil {
+		cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: outcomesConfigMap}}
+		cm.Data = map[string]string{outcomesKey: string(document)}
+		_, err = clientset.CoreV1().ConfigMaps(caNamespace).Create(context.Background(), cm, metav1.CreateOptions{})
+		return err
+	}
+	cm.Data = map[string]string{outcomesKey: string(document)}
+	_, err = clientset.CoreV1().ConfigMaps(caNamespace).Update(context.Background(), cm, metav1.UpdateOptions{})
+	return err
+}
+
+// nodeReadyTimes returns when the node of every instance became Ready
+func nodeReadyTimes(clientset kubernetes.Interface) (map[string]time.Time, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(map[string]time.Time)
+	for _, node := range nodes.Items {
+		// aws:///us-east-1a/i-0123456789abcdef0
+		instanceID := node.Spec.ProviderID[strings.LastIndex(node.Spec.ProviderID, "/")+1:]
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady && condition.Status == v1.ConditionTrue {
+				ready[instanceID] = condition.LastTransitionTime.Time
+			}
+		}
+	}
+	return ready, nil
+}
+
+// recordOutcomes counts the launch activities of the ASG finished since the
+// last run
+func recordOutcomes(asg *asgInfo, history *asgOutcomes, ready map[string]time.Time) error {
+	output, err := asg.api().autoscaling.DescribeScalingActivities(&autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(asg.Name),
+		MaxRecords:           aws.Int64(50),
+	})
+	if err != nil {
+		return err
+	}
+	countOutcomes(asg, history, output.Activities, ready)
+	return nil
+}
+
+// countOutcomes adds the finished launch activities newer than the last one
+// counted to the history, activities coming newest first
+func countOutcomes(asg *asgInfo, history *asgOutcomes, activities []*autoscaling.Activity, ready map[string]time.Time) {
+	for i := len(activities) - 1; i >= 0; i-- {
+		activity := activities[i]
+		started := aws.TimeValue(activity.StartTime)
+		if !started.After(history.LastActivity) {
+			continue
+		}
+		description := aws.StringValue(activity.Description)
+		if !strings.HasPrefix(description, launchActivityPrefix) {
+			continue
+		}
+
+		switch aws.StringValue(activity.StatusCode) {
+		case autoscaling.ScalingActivityStatusCodeSuccessful:
+			launch := launchedInstancePattern.FindStringSubmatch(description)
+			if launch == nil {
+				// no instance to follow, trust the activity
+				history.Successes = history.Successes*outcomeDecay + 1
+				history.Failures *= outcomeDecay
+				break
+			}
+			readyAt, ok := ready[launch[1]]
+			if !ok && time.Since(started) < outcomeReadyTimeout {
+				// not Ready yet, count it once it is
+				return
+			}
+			if !ok {
+				// registered but never became Ready
+				history.Successes *= outcomeDecay
+				history.Failures = history.Failures*outcomeDecay + 1
+				break
+			}
+			history.Successes = history.Successes*outcomeDecay + 1
+			history.Failures *= outcomeDecay
+			seconds := readyAt.Sub(started).Seconds()
+			if history.ReadySeconds == 0 {
+				history.ReadySeconds = seconds
+			} else {
+				history.ReadySeconds = history.ReadySeconds*outcomeDecay + seconds*(1-outcomeDecay)
+			}
+		case autoscaling.ScalingActivityStatusCodeFailed:
+			history.Successes *= outcomeDecay
+			history.Failures = history.Failures*outcomeDecay + 1
+			if debug {
+				fmt.Printf("DEBUG: failed launch on %s: %s\n", asg.Name, aws.StringValue(activity.StatusMessage))
+			}
+		case autoscaling.ScalingActivityStatusCodeCancelled:
+			// neither outcome, just skip past it
+		default:
+			// still running, count it next time
+			return
+		}
+
+		history.LastActivity = started
+	}
+}
+
+// applyOutcomeLearning records the latest scale-up outcomes of the matched
+// ASGs in the outcomes store and scales their scores by their historical
+// success rate
+func applyOutcomeLearning(clientset kubernetes.Interface, matched []*asgInfo, scores map[string]int) {
+	outcomes, cm, err := loadOutcomes(clientset)
+	if err != nil {
+		fmt.Printf("Unable to load scale-up outcomes: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "outcomes"}, 1)
+		return
+	}
+	ready, err := nodeReadyTimes(clientset)
+	if err != nil {
+		fmt.Printf("Unable to list nodes: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "kubernetes"}, 1)
+		return
+	}
+
+	for _, asg := range matched {
+		history, ok := outcomes[asg.key()]
+		if !ok {
+			history = &asgOutcomes{}
+			outcomes[asg.key()] = history
+		}
+		if err := recordOutcomes(asg, history, ready); err != nil {
+			fmt.Printf("Error retrieving scaling activities of ASG %s: %v\n", asg.Name, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		}
+
+		rate, ok := history.successRate()
+		if !ok {
+			// no scale-ups seen yet, nothing to learn from
+			continue
+		}
+		score := int(float64(scores[asg.key()]) * rate)
+		if debug {
+			fmt.Printf("DEBUG: %s success rate %.2f, score %d -> %d\n", asg.Name, rate, scores[asg.key()], score)
+		}
+		scores[asg.key()] = score
+	}
+
+	if err := saveOutcomes(clientset, outcomes, cm); err != nil {
+		fmt.Printf("Unable to save scale-up outcomes: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "outcomes"}, 1)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestCountOutcomes(t *testing.T) {
+	now := time.Now()
+	activities := []*autoscaling.Activity{
+		{
+			// newest first, as DescribeScalingActivities returns them
+			Description: aws.String("Launching a new EC2 instance.  Status Reason: We currently do not have sufficient m5.large capacity in the Availability Zone you requested."),
+			StatusCode:  aws.String(autoscaling.ScalingActivityStatusCodeFailed),
+			StartTime:   aws.Time(now.Add(-20 * time.Minute)),
+		},
+		{
+			Description: aws.String("Terminating EC2 instance: i-0aaaaaaaaaaaaaaaa"),
+			StatusCode:  aws.String(autoscaling.ScalingActivityStatusCodeSuccessful),
+			StartTime:   aws.Time(now.Add(-25 * time.Minute)),
+		},
+		{
+			Description: aws.String("Launching a new EC2 instance: i-0123456789abcdef0"),
+			StatusCode:  aws.String(autoscaling.ScalingActivityStatusCodeSuccessful),
+			StartTime:   aws.Time(now.Add(-30 * time.Minute)),
+		},
+	}
+	ready := map[string]time.Time{"i-0123456789abcdef0": now.Add(-28 * time.Minute)}
+
+	history := &asgOutcomes{}
+	countOutcomes(&asgInfo{Name: "workers"}, history, activities, ready)
+
+	if want := outcomeDecay; history.Successes != want {
+		t.Errorf("Successes = %v, want %v", history.Successes, want)
+	}
+	if history.Failures != 1 {
+		t.Errorf("Failures = %v, want 1 for the capacity failure", history.Failures)
+	}
+	if history.ReadySeconds != 120 {
+		t.Errorf("ReadySeconds = %v, want 120", history.ReadySeconds)
+	}
+	if !history.LastActivity.Equal(now.Add(-20 * time.Minute)) {
+		t.Errorf("LastActivity = %v, want the failed launch", history.LastActivity)
+	}
+}
+
+func TestCountOutcomesWaitsForReadiness(t *testing.T) {
+	history := &asgOutcomes{}
+	countOutcomes(&asgInfo{Name: "workers"}, history, []*autoscaling.Activity{{
+		Description: aws.String("Launching a new EC2 instance: i-0123456789abcdef0"),
+		StatusCode:  aws.String(autoscaling.ScalingActivityStatusCodeSuccessful),
+		StartTime:   aws.Time(time.Now().Add(-time.Minute)),
+	}}, map[string]time.Time{})
+
+	if history.Successes != 0 || history.Failures != 0 || !history.LastActivity.IsZero() {
+		t.Errorf("history = %+v, want the launch left for a later run", history)
+	}
+}
+
+func TestSuccessRate(t *testing.T) {
+	if _, ok := (asgOutcomes{}).successRate(); ok {
+		t.Error("successRate() reported a rate without history")
+	}
+	if rate, ok := (asgOutcomes{Successes: 3, Failures: 1}).successRate(); !ok || rate != 4.0/6 {
+		t.Errorf("successRate() = %v, %v, want %v, true", rate, ok, 4.0/6)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// formats accepted by OUTPUT_FORMAT. Anything but configmap writes the
+// document to OUTPUT_FILE, or stdout, instead of the cluster so it can go
+// through an infrastructure-as-code change process
+const (
+	outputConfigMap = "configmap"
+	outputTerraform = "terraform"
+	outputTFVars    = "tfvars"
+)
+
+// validOutputFormat reports whether OUTPUT_FORMAT is one we know
+func validOutputFormat() bool {
+	switch outputFormat {
+	case outputConfigMap, outputTerraform, outputTFVars:
+		return true
+	}
+	return false
+}
+
+// terraformIdentifier turns a Kubernetes name into a Terraform identifier
+func terraformIdentifier(name string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(name)
+}
+
+// renderTerraform renders the configmap as a kubernetes_config_map resource
+func renderTerraform(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// heredocs still interpolate, escape template sequences
+	escaper := strings.NewReplacer("${", "$${", "%{", "%%{")
+
+	var hcl strings.Builder
+	fmt.Fprintf(&hcl, "resource \"kubernetes_config_map\" %q {\n", terraformIdentifier(caPriorityExpander))
+	hcl.WriteString("  metadata {\n")
+	fmt.Fprintf(&hcl, "    name      = %q\n", caPriorityExpander)
+	fmt.Fprintf(&hcl, "    namespace = %q\n", caNamespace)
+	hcl.WriteString("  }\n\n")
+	hcl.WriteString("  data = {\n")
+	for _, key := range keys {
+		fmt.Fprintf(&hcl, "    %q = <<-EOT\n", key)
+		for _, line := range strings.Split(strings.TrimSuffix(data[key], "\n"), "\n") {
+			fmt.Fprintf(&hcl, "      %s\n", escaper.Replace(line))
+		}
+		hcl.WriteString("    EOT\n")
+	}
+	hcl.WriteString("  }\n")
+	hcl.WriteString("}\n")
+	return hcl.String()
+}
+
+// renderTFVars renders the configmap as a tfvars JSON document
+func renderTFVars(data map[string]string) (string, error) {
+	document, err := json.MarshalIndent(map[string]interface{}{
+		terraformIdentifier(caPriorityExpander): map[string]interface{}{
+			"name":      caPriorityExpander,
+			"namespace": caNamespace,
+			"data":      data,
+		},
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(document) + "\n", nil
+}
+
+// writeOutput writes the configmap data in OUTPUT_FORMAT to OUTPUT_FILE, or
+// stdout if unset
+func writeOutput(data map[string]string) error {
+	var document string
+	switch outputFormat {
+	case outputTerraform:
+		document = renderTerraform(data)
+	case outputTFVars:
+		var err error
+		if document, err = renderTFVars(data); err != nil {
+			return err
+		}
+	}
+
+	if outputFile == "" {
+		fmt.Print(document)
+		return nil
+	}
+	// the document is deterministic, an identical file means nothing changed
+	if current, err := os.ReadFile(outputFile); err == nil && bytes.Equal(current, []byte(document)) {
+		if debug {
+			fmt.Printf("DEBUG: %s output %s is up to date\n", outputFormat, outputFile)
+		}
+		return nil
+	}
+	if err := os.WriteFile(outputFile, []byte(document), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s output: %s\n", outputFormat, outputFile)
+	return nil
+}
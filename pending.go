@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// pendingSubnetIPs returns the IPs the instances the ASGs are still
+// launching, their DesiredCapacity minus the instances they already have,
+// will take from each subnet. Launches are spread evenly over the subnets of
+// their ASG, and each takes the IPs of a node of its largest instance type,
+// or one when unknown. The IPs are counted against the subnet scored for
+// each node subnet, its pod subnet under CUSTOM_NETWORKING
+func pendingSubnetIPs(asgs []*asgInfo, instanceTypes map[string]*ec2.InstanceTypeInfo, scoredSubnet func(asg *asgInfo, subnetID string) string) map[string]int {
+	pending := make(map[string]int)
+	for _, asg := range asgs {
+		launching := int(asg.DesiredCapacity - asg.instances)
+		if launching <= 0 || len(asg.subnetIDs) == 0 {
+			continue
+		}
+
+		perNode, err := asgNodeIPs(asg, instanceTypes)
+		if err != nil {
+			fmt.Printf("Error resolving instance types for ASG %s, counting one IP per pending instance: %v\n", asg.Name, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		}
+		if perNode == 0 {
+			perNode = 1
+		}
+
+		share := (launching*perNode + len(asg.subnetIDs) - 1) / len(asg.subnetIDs)
+		if debug {
+			fmt.Printf("DEBUG: %s is launching %d instances, taking %d IPs from each of its subnets\n", asg.Name, launching, share)
+		}
+		for _, subnetID := range asg.subnetIDs {
+			pending[scoredSubnet(asg, subnetID)] += share
+		}
+	}
+	return pending
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestPendingSubnetIPsCountAgainstTheScoredSubnet(t *testing.T) {
+	defer func(observe bool) { observeNodeIPs = observe }(observeNodeIPs)
+	observeNodeIPs = false
+
+	asgs := []*asgInfo{
+		// no known instance type, one IP per pending instance
+		{Name: "workers", DesiredCapacity: 6, instances: 2, subnetIDs: []string{"subnet-node-a", "subnet-node-b"}},
+		{Name: "steady", DesiredCapacity: 2, instances: 2, subnetIDs: []string{"subnet-node-a"}},
+	}
+	podSubnets := map[string]string{"subnet-node-a": "subnet-pod-a", "subnet-node-b": "subnet-pod-b"}
+
+	tests := []struct {
+		name         string
+		scoredSubnet func(*asgInfo, string) string
+		want         map[string]int
+	}{
+		{
+			name:         "node subnets",
+			scoredSubnet: func(_ *asgInfo, subnetID string) string { return subnetID },
+			want:         map[string]int{"subnet-node-a": 2, "subnet-node-b": 2},
+		},
+		{
+			name:         "custom networking",
+			scoredSubnet: func(_ *asgInfo, subnetID string) string { return podSubnets[subnetID] },
+			want:         map[string]int{"subnet-pod-a": 2, "subnet-pod-b": 2},
+		},
+		{
+			name:         "shared pod subnet",
+			scoredSubnet: func(*asgInfo, string) string { return "subnet-pod" },
+			want:         map[string]int{"subnet-pod": 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pendingSubnetIPs(asgs, make(map[string]*ec2.InstanceTypeInfo), tt.scoredSubnet)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pendingSubnetIPs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// awsReservedIPs is the number of addresses AWS keeps in every IPv4 subnet
+const awsReservedIPs = 5
+
+// subnetCapacity returns how many of what subnetFreeIPs counts the subnet
+// holds in total: the node prefixes of IPv6-only subnets with IP_FAMILY ipv6,
+// the usable addresses of its IPv4 CIDR otherwise
+func subnetCapacity(subnet *ec2.Subnet) int {
+	if ipFamily == ipFamilyIPv6 && aws.BoolValue(subnet.Ipv6Native) {
+		return ipv6NodePrefixes(subnet)
+	}
+	_, cidr, err := net.ParseCIDR(aws.StringValue(subnet.CidrBlock))
+	if err != nil {
+		return 0
+	}
+	ones, bits := cidr.Mask.Size()
+	return 1<<uint(bits-ones) - awsReservedIPs
+}
+
+// freeIPsPercent returns the free IPs of the subnet as a percentage of its
+// capacity, so small dedicated subnets aren't always ranked below large shared
+// ones
+func freeIPsPercent(subnet *ec2.Subnet, freeIPs int) int {
+	capacity := subnetCapacity(subnet)
+	if capacity <= 0 {
+		return 0
+	}
+	if freeIPs > capacity {
+		return 100
+	}
+	return freeIPs * 100 / capacity
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ipv4PrefixSize is the number of addresses in the /28 prefixes the VPC CNI
+// assigns to ENIs with prefix delegation
+const ipv4PrefixSize = 16
+
+// ipv4Offset returns the position of the address in the block starting at
+// base, or false when it isn't an IPv4 address
+func ipv4Offset(base net.IP, ip net.IP) (int64, bool) {
+	base, ip = base.To4(), ip.To4()
+	if base == nil || ip == nil {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint32(ip)) - int64(binary.BigEndian.Uint32(base)), true
+}
+
+// prefixBlocks tracks which aligned /28 prefixes of a subnet are taken
+type prefixBlocks struct {
+	base  net.IP
+	count int64
+	used  map[int64]bool
+}
+
+// newPrefixBlocks returns the blocks of the subnet, the first and last ones
+// taken by the addresses AWS reserves
+func newPrefixBlocks(cidr *net.IPNet) *prefixBlocks {
+	ones, bits := cidr.Mask.Size()
+	blocks := &prefixBlocks{base: cidr.IP, count: int64(1<<uint(bits-ones)) / ipv4PrefixSize}
+	blocks.used = map[int64]bool{0: true, blocks.count - 1: true}
+	return blocks
+}
+
+// take marks the blocks overlapping size addresses from ip as used,
+// ignoring addresses outside the subnet
+func (p *prefixBlocks) take(ip net.IP, size int64) {
+	offset, ok := ipv4Offset(p.base, ip)
+	if !ok {
+		return
+	}
+	for block := offset / ipv4PrefixSize; block <= (offset+size-1)/ipv4PrefixSize; block++ {
+		if block >= 0 && block < p.count {
+			p.used[block] = true
+		}
+	}
+}
+
+// free returns the blocks nothing was taken from
+func (p *prefixBlocks) free() int {
+	if p.count == 0 {
+		return 0
+	}
+	return int(p.count) - len(p.used)
+}
+
+// subnetFreePrefixes returns how many aligned /28 prefixes of the subnet are
+// still available: no address of theirs is assigned to an ENI, taken by one of
+// its prefixes, held by an explicit CIDR reservation or reserved by AWS (the
+// first four and the last addresses of the subnet)
+func subnetFreePrefixes(client *ec2.EC2, subnet *ec2.Subnet, reservations []*net.IPNet) (int, error) {
+	subnetID := aws.StringValue(subnet.SubnetId)
+	_, cidr, err := net.ParseCIDR(aws.StringValue(subnet.CidrBlock))
+	if err != nil {
+		return 0, fmt.Errorf("subnet %s has no valid IPv4 CIDR: %v", subnetID, err)
+	}
+	blocks := newPrefixBlocks(cidr)
+	if blocks.count == 0 {
+		return 0, nil
+	}
+
+	err = client.DescribeNetworkInterfacesPages(&ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("subnet-id"), Values: []*string{aws.String(subnetID)}}},
+	}, func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+		for _, eni := range page.NetworkInterfaces {
+			for _, address := range eni.PrivateIpAddresses {
+				blocks.take(net.ParseIP(aws.StringValue(address.PrivateIpAddress)), 1)
+			}
+			for _, prefix := range eni.Ipv4Prefixes {
+				if _, block, err := net.ParseCIDR(aws.StringValue(prefix.Ipv4Prefix)); err == nil {
+					blocks.take(block.IP, ipv4PrefixSize)
+				}
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, reservation := range reservations {
+		ones, bits := reservation.Mask.Size()
+		blocks.take(reservation.IP, int64(1)<<uint(bits-ones))
+	}
+
+	return blocks.free(), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPrefixBlocks(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidr  string
+		taken []string
+		want  int
+	}{
+		// 16 blocks, the first and last reserved by AWS
+		{name: "empty /24", cidr: "10.0.1.0/24", want: 14},
+		{name: "single addresses", cidr: "10.0.1.0/24", taken: []string{"10.0.1.20/32", "10.0.1.21/32", "10.0.1.40/32"}, want: 12},
+		{name: "prefix", cidr: "10.0.1.0/24", taken: []string{"10.0.1.48/28"}, want: 13},
+		{name: "reservation spanning blocks", cidr: "10.0.1.0/24", taken: []string{"10.0.1.64/26"}, want: 10},
+		{name: "outside the subnet", cidr: "10.0.1.0/24", taken: []string{"10.0.2.20/32", "10.0.0.240/28"}, want: 14},
+		{name: "reserved blocks again", cidr: "10.0.1.0/24", taken: []string{"10.0.1.5/32", "10.0.1.250/32"}, want: 14},
+		{name: "single block", cidr: "10.0.1.0/28", want: 0},
+		{name: "smaller than a block", cidr: "10.0.1.0/29", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cidr, err := net.ParseCIDR(tt.cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks := newPrefixBlocks(cidr)
+			for _, taken := range tt.taken {
+				_, block, err := net.ParseCIDR(taken)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ones, bits := block.Mask.Size()
+				blocks.take(block.IP, int64(1)<<uint(bits-ones))
+			}
+			if got := blocks.free(); got != tt.want {
+				t.Errorf("free() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Profiles let one instance feed several cluster-autoscalers, one per
+// workload class. PROFILES lists their names and every setting can be
+// overridden for a profile by prefixing it with PROFILE_<NAME>_, e.g.
+//
+//	PROFILES=general,gpu
+//	PROFILE_GPU_LT_CONTAINS=gpu
+//	PROFILE_GPU_CA_NAMESPACE=ca-gpu
+//
+// ASGs are discovered once per run, using the base ASG_CONTAINS, and
+// reconciled for each profile in turn
+
+// name of the profile being reconciled, empty outside of profiles
+var activeProfile string
+
+// reconcileState is what a reconcile remembers between runs
+type reconcileState struct {
+	previousScores map[string]int
+	rolloutScores  map[string]int
+	deferringSince time.Time
+}
+
+// state remembered for every profile
+var profileStates = make(map[string]reconcileState)
+
+// profileLookup returns the settings of a profile on top of the base lookup
+func profileLookup(name string, base func(string) string) func(string) string {
+	prefix := "PROFILE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	return func(key string) string {
+		if value := base(prefix + key); value != "" {
+			return value
+		}
+		return base(key)
+	}
+}
+
+// withProfile runs fn with the settings and state of the given profile,
+// restoring the base settings afterwards
+func withProfile(name string, fn func() error) error {
+	base := rulesLookup(rulesSettings)
+	loadConfig(profileLookup(name, base))
+	activeProfile = name
+	state := profileStates[name]
+	previousScores, rolloutScores, deferringSince = state.previousScores, state.rolloutScores, state.deferringSince
+
+	err := fn()
+
+	profileStates[name] = reconcileState{previousScores, rolloutScores, deferringSince}
+	previousScores, rolloutScores, deferringSince = nil, nil, time.Time{}
+	activeProfile = ""
+	loadConfig(base)
+	return err
+}
+
+// profileLabels returns the labels identifying the active profile's metric
+// series
+func profileLabels() map[string]string {
+	if activeProfile == "" {
+		return nil
+	}
+	return map[string]string{"profile": activeProfile}
+}
+
+// profileASGs returns copies of the discovered ASGs for a profile to fill in.
+// Tags are shared so tag changes made for one profile are seen by the next
+func profileASGs(asgs []*asgInfo) []*asgInfo {
+	copies := make([]*asgInfo, 0, len(asgs))
+	for _, asg := range asgs {
+		copied := *asg
+		copied.Subnets = nil
+		copied.FreeIPs = 0
+		copies = append(copies, &copied)
+	}
+	return copies
+}
+
+// validateProfiles checks the settings of every profile and that no two of
+// them write the same configmap or output file, then reloads the base
+// settings
+func validateProfiles(base func(string) string) error {
+	defer loadConfig(base)
+
+	targets := make(map[string]string)
+	for _, name := range splitList(base("PROFILES")) {
+		loadConfig(profileLookup(name, base))
+		if err := validateConfig(); err != nil {
+			return fmt.Errorf("profile %s: %v", name, err)
+		}
+		if manageCATags {
+			return fmt.Errorf("profile %s: MANAGE_CA_TAGS can't be used with profiles", name)
+		}
+		target := caNamespace + "/" + caPriorityExpander
+		if outputFormat != outputConfigMap && outputFile != "" {
+			target = outputFile
+		}
+		if other, ok := targets[target]; ok {
+			return fmt.Errorf("profiles %s and %s both write %s", other, name, target)
+		}
+		targets[target] = name
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+)
+
+// onDemandQuotaCodes are the Service Quotas codes of the running on-demand
+// vCPU quota of every instance class
+var onDemandQuotaCodes = map[string]string{
+	"standard": "L-1216C47A",
+	"g":        "L-DB2E81BA",
+	"p":        "L-417A185B",
+	"x":        "L-7295265B",
+	"f":        "L-74FC7D96",
+	"inf":      "L-1945791B",
+	"dl":       "L-6E869C2A",
+	"trn":      "L-2C3B7624",
+	"hpc":      "L-F7808C92",
+	"u":        "L-43DA4232",
+}
+
+// networkInterfacesQuotaCode is the Service Quotas code of the network
+// interfaces per region quota of VPC
+const networkInterfacesQuotaCode = "L-DF5E4CA3"
+
+// quotaClass returns the vCPU quota class of an instance type, e.g.
+// "standard" for m5.large or "g" for g4dn.xlarge, or an empty string if it
+// isn't covered by a vCPU quota
+func quotaClass(instanceType string) string {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	end := strings.IndexAny(family, "0123456789-")
+	if end < 0 {
+		end = len(family)
+	}
+	letters := strings.ToLower(family[:end])
+
+	switch letters {
+	case "":
+		return ""
+	case "inf", "dl", "trn", "hpc", "u", "p", "x", "f":
+		return letters
+	case "g", "vt":
+		return "g"
+	case "mac":
+		return ""
+	}
+	if strings.ContainsRune("acdhimrtz", rune(letters[0])) {
+		return "standard"
+	}
+	return ""
+}
+
+// onDemandVCPUUsage returns the vCPUs of the running and pending on-demand
+// instances of the account in the region of the client, by quota class
+func onDemandVCPUUsage(client *ec2.EC2) (map[string]int64, error) {
+	usage := make(map[string]int64)
+	err := client.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("instance-state-name"),
+			Values: []*string{aws.String("pending"), aws.String(ec2.InstanceStateNameRunning)},
+		}},
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if aws.StringValue(instance.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot || instance.CpuOptions == nil {
+					continue
+				}
+				class := quotaClass(aws.StringValue(instance.InstanceType))
+				usage[class] += aws.Int64Value(instance.CpuOptions.CoreCount) * aws.Int64Value(instance.CpuOptions.ThreadsPerCore)
+			}
+		}
+		return !lastPage
+	})
+	return usage, err
+}
+
+// networkInterfaceCount returns the network interfaces of the account in
+// the region of the client
+func networkInterfaceCount(client *ec2.EC2) (int64, error) {
+	var count int64
+	err := client.DescribeNetworkInterfacesPages(&ec2.DescribeNetworkInterfacesInput{},
+		func(page *ec2.DescribeNetworkInterfacesOutput, lastPage bool) bool {
+			count += int64(len(page.NetworkInterfaces))
+			return !lastPage
+		})
+	return count, err
+}
+
+// applyQuotaDemotion demotes the ASGs whose instance classes have all used
+// at least QUOTA_THRESHOLD_PERCENT of their on-demand vCPU quota, or whose
+// account has used that much of its network interfaces per region quota,
+// since scale-ups there fail right away and waste CA's retries whatever the
+// free IPs of their subnets. Usage and quotas are per account and region
+func applyQuotaDemotion(matched []*asgInfo, scores map[string]int, status statusReport) {
+	usages := make(map[string]map[string]int64)
+	interfaces := make(map[string]string)
+	limits := make(map[string]float64)
+	quota := func(clients *awsClients, serviceCode, quotaCode string) (float64, error) {
+		key := clients.String() + "/" + serviceCode + "/" + quotaCode
+		if limit, ok := limits[key]; ok {
+			return limit, nil
+		}
+		client := servicequotas.New(clients.session, &aws.Config{Region: aws.String(clients.region)})
+		output, err := client.GetServiceQuota(&servicequotas.GetServiceQuotaInput{
+			ServiceCode: aws.String(serviceCode),
+			QuotaCode:   aws.String(quotaCode),
+		})
+		if err != nil {
+			return 0, err
+		}
+		limits[key] = aws.Float64Value(output.Quota.Value)
+		return limits[key], nil
+	}
+
+	// interfacesExhausted returns why the account is out of network
+	// interfaces in the region, or an empty string
+	interfacesExhausted := func(clients *awsClients) string {
+		if reason, ok := interfaces[clients.String()]; ok {
+			return reason
+		}
+		reason := ""
+		count, err := networkInterfaceCount(clients.ec2)
+		if err == nil {
+			var limit float64
+			limit, err = quota(clients, "vpc", networkInterfacesQuotaCode)
+			if err == nil && float64(count) >= limit*float64(quotaThresholdPercent)/100 {
+				// the usage moves every run, keep it out of the status
+				fmt.Printf("%d/%.0f network interfaces used in %s\n", count, limit, clients)
+				reason = "network interfaces per region quota nearly used"
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error retrieving the network interfaces quota usage in %s: %v\n", clients, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+		}
+		interfaces[clients.String()] = reason
+		return reason
+	}
+
+	for _, asg := range matched {
+		clients := asg.api()
+		if reason := interfacesExhausted(clients); reason != "" {
+			demoteASG(scores, status, asg.key(), reason)
+			continue
+		}
+
+		usage, ok := usages[clients.String()]
+		if !ok {
+			var err error
+			usage, err = onDemandVCPUUsage(clients.ec2)
+			if err != nil {
+				fmt.Printf("Error retrieving vCPU usage in %s: %v\n", clients, err)
+				metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+				continue
+			}
+			usages[clients.String()] = usage
+		}
+
+		types, err := asgInstanceTypes(asg)
+		if err != nil {
+			fmt.Printf("Error resolving instance types for ASG %s: %v\n", asg.Name, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+			continue
+		}
+
+		var exhausted []string
+		classes := make(map[string]bool)
+		for _, instanceType := range types {
+			class := quotaClass(instanceType)
+			if class == "" || classes[class] {
+				continue
+			}
+			classes[class] = true
+
+			limit, err := quota(clients, "ec2", onDemandQuotaCodes[class])
+			if err != nil {
+				fmt.Printf("Error retrieving the %s vCPU quota: %v\n", class, err)
+				metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+				exhausted = nil
+				break
+			}
+			if float64(usage[class]) < limit*float64(quotaThresholdPercent)/100 {
+				exhausted = nil
+				break
+			}
+			exhausted = append(exhausted, class)
+			if debug {
+				fmt.Printf("DEBUG: %s %d/%.0f on-demand vCPUs used in %s\n", class, usage[class], limit, clients)
+			}
+		}
+
+		if len(exhausted) > 0 {
+			demoteASG(scores, status, asg.key(), "on-demand vCPU quota nearly used: "+strings.Join(exhausted, ", "))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// networkClients are the clients of the SHARED_SUBNET_ROLE_ARNS roles by role
+// and region, created on first use
+var networkClients = make(map[string]*awsClients)
+
+// roleAccount returns the account ID of a role ARN
+func roleAccount(arn string) string {
+	if parts := strings.Split(arn, ":"); len(parts) > 4 {
+		return parts[4]
+	}
+	return ""
+}
+
+// networkAccountClients returns the clients in the region of the
+// SHARED_SUBNET_ROLE_ARNS roles of the account, or of every role when the
+// account is empty
+func networkAccountClients(account, region string) []*awsClients {
+	var clients []*awsClients
+	for _, role := range sharedSubnetRoleARNs {
+		if account != "" && roleAccount(role) != account {
+			continue
+		}
+		key := role + " in " + region
+		roleClients, ok := networkClients[key]
+		if !ok {
+			roleClients = newAWSClients(assumeRoleSession(awsSession, role), role, region)
+			networkClients[key] = roleClients
+		}
+		clients = append(clients, roleClients)
+	}
+	return clients
+}
+
+// sharedSubnetOwned reports whether a subnet described through the clients
+// is shared through AWS RAM by an owner SHARED_SUBNET_ROLE_ARNS has a role in
+func sharedSubnetOwned(clients *awsClients, owner string) bool {
+	if owner == "" || roleAccount(clients.role) == owner {
+		return false
+	}
+	return len(networkAccountClients(owner, clients.region)) > 0
+}
+
+// describeSharedSubnet describes a subnet shared through AWS RAM as the
+// network account owning it, or as every SHARED_SUBNET_ROLE_ARNS role in turn
+// when the owner is unknown, returning it with the clients that found it
+func describeSharedSubnet(owner, region, subnetID string) (*ec2.Subnet, *awsClients, error) {
+	err := fmt.Errorf("subnet %s not found", subnetID)
+	for _, clients := range networkAccountClients(owner, region) {
+		output, describeErr := clients.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+			SubnetIds: []*string{aws.String(subnetID)},
+		})
+		if describeErr != nil {
+			err = describeErr
+			continue
+		}
+		if len(output.Subnets) > 0 {
+			if debug {
+				fmt.Printf("DEBUG: described shared subnet %s as %s\n", subnetID, clients)
+			}
+			return output.Subnets[0], clients, nil
+		}
+	}
+	return nil, nil, err
+}
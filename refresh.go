@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// INSTANCE_REFRESH_ACTION values
+const (
+	instanceRefreshDemote  = "demote"
+	instanceRefreshExclude = "exclude"
+)
+
+// activeInstanceRefreshStatuses are the instance refresh statuses during
+// which the ASG's instances are being replaced
+var activeInstanceRefreshStatuses = map[string]bool{
+	autoscaling.InstanceRefreshStatusPending:            true,
+	autoscaling.InstanceRefreshStatusInProgress:         true,
+	autoscaling.InstanceRefreshStatusCancelling:         true,
+	autoscaling.InstanceRefreshStatusRollbackInProgress: true,
+}
+
+// validInstanceRefreshAction reports whether INSTANCE_REFRESH_ACTION is
+// supported
+func validInstanceRefreshAction() bool {
+	switch instanceRefreshAction {
+	case "", instanceRefreshDemote, instanceRefreshExclude:
+		return true
+	}
+	return false
+}
+
+// instanceRefresh returns a description of the ASG's active instance
+// refresh, or an empty string when there is none
+func instanceRefresh(asg *asgInfo) (string, error) {
+	output, err := asg.api().autoscaling.DescribeInstanceRefreshes(&autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(asg.Name),
+		MaxRecords:           aws.Int64(1),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, refresh := range output.InstanceRefreshes {
+		if activeInstanceRefreshStatuses[aws.StringValue(refresh.Status)] {
+			// the progress moves every run, keep it out of the status
+			if debug {
+				fmt.Printf("DEBUG: instance refresh of %s is %d%% complete\n", asg.Name, aws.Int64Value(refresh.PercentageComplete))
+			}
+			return fmt.Sprintf("instance refresh %s %s", aws.StringValue(refresh.InstanceRefreshId), aws.StringValue(refresh.Status)), nil
+		}
+	}
+	return "", nil
+}
+
+// checkInstanceRefresh returns the ASG's active instance refresh, logging
+// lookup failures, which leave the ASG alone
+func checkInstanceRefresh(asg *asgInfo) string {
+	refresh, err := instanceRefresh(asg)
+	if err != nil {
+		fmt.Printf("Error retrieving instance refreshes of ASG %s: %v\n", asg.Name, err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "aws"}, 1)
+	}
+	return refresh
+}
+
+// applyInstanceRefreshDemotion demotes the ASGs with an instance refresh
+// in progress so CA prefers stable groups during image rollouts
+func applyInstanceRefreshDemotion(matched []*asgInfo, scores map[string]int, status statusReport) {
+	for _, asg := range matched {
+		if refresh := checkInstanceRefresh(asg); refresh != "" {
+			demoteASG(scores, status, asg.key(), refresh)
+		}
+	}
+}
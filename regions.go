@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+// awsClients are the API clients of a single region, in the controller's
+// account or in the one of a discovery role
+type awsClients struct {
+	region string
+	// role assumed to reach another account, empty for the controller's
+	role        string
+	session     *session.Session
+	autoscaling *autoscaling.AutoScaling
+	ec2         *ec2.EC2
+	eks         *eks.EKS
+}
+
+var (
+	// clients of REGION, used for everything that isn't tied to an ASG
+	homeClients *awsClients
+	// clients of every account and region ASGs are discovered in
+	regionClients []*awsClients
+)
+
+// newAWSClients creates the API clients of the region
+func newAWSClients(sess *session.Session, role, region string) *awsClients {
+	config := &aws.Config{Region: aws.String(region)}
+	return &awsClients{
+		region:      region,
+		role:        role,
+		session:     sess,
+		autoscaling: autoscaling.New(sess, config),
+		ec2:         ec2.New(sess, config),
+		eks:         eks.New(sess, config),
+	}
+}
+
+// region of the instance the pod runs on, from the instance metadata, used
+// when no region is configured
+var detectedRegion string
+
+// imdsRegion asks the EC2 instance metadata service for the region, returning
+// an empty string when it isn't reachable
+func imdsRegion() string {
+	sess, err := session.NewSession()
+	if err != nil {
+		return ""
+	}
+	region, err := ec2metadata.New(sess, &aws.Config{MaxRetries: aws.Int(1)}).Region()
+	if err != nil {
+		fmt.Printf("Unable to detect the region from the instance metadata: %v\n", err)
+		return ""
+	}
+	if debug {
+		fmt.Println("DEBUG: detected region " + region)
+	}
+	return region
+}
+
+// discoveryRegions returns the regions listed in REGIONS, or REGION alone
+func discoveryRegions() []string {
+	if len(regions) == 0 {
+		return []string{setRegion}
+	}
+	return regions
+}
+
+// newRegionClients returns the clients of every discovery region, in the
+// controller's account and in the account of every discovery role, reusing
+// the home clients for REGION
+func newRegionClients(sess *session.Session) []*awsClients {
+	var clients []*awsClients
+	for _, region := range discoveryRegions() {
+		if region == homeClients.region {
+			clients = append(clients, homeClients)
+			continue
+		}
+		clients = append(clients, newAWSClients(sess, "", region))
+	}
+
+	for _, role := range discoveryRoles(sess) {
+		roleSession := assumeRoleSession(sess, role)
+		if debug {
+			fmt.Println("DEBUG: discovering ASGs as " + role)
+		}
+		for _, region := range discoveryRegions() {
+			clients = append(clients, newAWSClients(roleSession, role, region))
+		}
+	}
+	return clients
+}
+
+// assumeRoleSession returns a copy of the session acting as the role
+func assumeRoleSession(sess *session.Session, role string) *session.Session {
+	return sess.Copy(&aws.Config{Credentials: stscreds.NewCredentials(sess, role, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = roleSessionName
+		p.Duration = assumeRoleDuration
+		p.ExpiryWindow = credentialsExpiryWindow
+	})})
+}
+
+// String identifies the account and region of the clients in logs
+func (c *awsClients) String() string {
+	if c.role == "" {
+		return c.region
+	}
+	return c.role + " in " + c.region
+}
+
+// qualifier namespaces the ASGs discovered through the clients: the region,
+// prefixed by the account of the discovery role
+func (c *awsClients) qualifier() string {
+	if c.role == "" {
+		return c.region
+	}
+	return roleAccount(c.role) + "/" + c.region
+}
+
+// api returns the clients of the account and region the ASG was discovered
+// in
+func (a *asgInfo) api() *awsClients {
+	if a.clients == nil {
+		return homeClients
+	}
+	return a.clients
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// renderPriorities builds the priority expander document, highest priority
+// first, with names sorted within each tier using NAME_COLLATION and listed
+// once, so the same priorities always render to the same bytes. With
+// ANCHOR_ENTRIES every name is written as an exact-match pattern, otherwise
+// only colliding names are when ANCHOR_COLLISIONS is set. Entries are
+// validated as regular expressions because CA refuses the whole document if
+// any of them is invalid. The STATIC_ENTRIES and catch-all patterns are
+// added after the ASGs of their tier, as double-quoted scalars
+func renderPriorities(caPriorities map[int][]string, collisions map[string][]string) (string, error) {
+	static := staticEntries()
+	keys := make([]int, 0, len(caPriorities)+len(static))
+	for k := range caPriorities {
+		keys = append(keys, k)
+	}
+	for k := range static {
+		if _, ok := caPriorities[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+
+	var priorities strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&priorities, "%d:\n", key)
+		names := append([]string(nil), caPriorities[key]...)
+		sortNames(names)
+		for i, asg := range names {
+			if i > 0 && asg == names[i-1] {
+				continue
+			}
+			if _, ok := collisions[asg]; anchorEntries || (ok && anchorCollisions) {
+				asg = anchoredPattern(asg)
+			}
+			if _, err := regexp.Compile(asg); err != nil {
+				return "", fmt.Errorf("invalid priority entry %q: %v", asg, err)
+			}
+			fmt.Fprintf(&priorities, "  - %s\n", asg)
+		}
+		for _, pattern := range static[key] {
+			// free-form patterns may start with YAML indicators like [ or *
+			fmt.Fprintf(&priorities, "  - %s\n", strconv.Quote(pattern))
+		}
+	}
+
+	return priorities.String(), nil
+}
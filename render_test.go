@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestRenderPrioritiesQuotesStaticEntries(t *testing.T) {
+	defer func(value string, enabled, anchor bool) {
+		staticEntriesValue, catchAll, anchorEntries = value, enabled, anchor
+	}(staticEntriesValue, catchAll, anchorEntries)
+
+	staticEntriesValue = `10=[a-z]+-gpu; 5=.*-spot-\d+`
+	catchAll, anchorEntries = false, false
+
+	got, err := renderPriorities(map[int][]string{10: {"workers-b", "workers-a"}}, nil)
+	if err != nil {
+		t.Fatalf("renderPriorities() error: %v", err)
+	}
+	want := "10:\n" +
+		"  - workers-a\n" +
+		"  - workers-b\n" +
+		"  - \"[a-z]+-gpu\"\n" +
+		"5:\n" +
+		"  - \".*-spot-\\\\d+\"\n"
+	if got != want {
+		t.Errorf("renderPriorities() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseStaticEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []staticEntry
+		wantErr bool
+	}{
+		{name: "empty", value: ""},
+		{
+			name:  "semicolons and newlines",
+			value: "5=.*-ondemand-.*;\n 1 = .*\n",
+			want:  []staticEntry{{priority: 5, pattern: ".*-ondemand-.*"}, {priority: 1, pattern: ".*"}},
+		},
+		{
+			name:  "commas and equals in pattern",
+			value: "3=a{1,2}=b",
+			want:  []staticEntry{{priority: 3, pattern: "a{1,2}=b"}},
+		},
+		{name: "missing pattern", value: "5=", wantErr: true},
+		{name: "missing priority", value: ".*", wantErr: true},
+		{name: "non-integer priority", value: "high=.*", wantErr: true},
+		{name: "invalid pattern", value: "5=(", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStaticEntries(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStaticEntries(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseStaticEntries(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseStaticEntries(%q)[%d] = %v, want %v", tt.value, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRenderPrioritiesQuotesCatchAll(t *testing.T) {
+	defer func(value string, enabled bool, priority int, pattern string) {
+		staticEntriesValue, catchAll, catchAllPriority, catchAllPattern = value, enabled, priority, pattern
+	}(staticEntriesValue, catchAll, catchAllPriority, catchAllPattern)
+
+	staticEntriesValue = ""
+	catchAll, catchAllPriority, catchAllPattern = true, 1, "[a-z]+-workers-.*"
+
+	got, err := renderPriorities(map[int][]string{1: {"workers"}}, nil)
+	if err != nil {
+		t.Fatalf("renderPriorities() error: %v", err)
+	}
+	if want := "1:\n  - workers\n  - \"[a-z]+-workers-.*\"\n"; got != want {
+		t.Errorf("renderPriorities() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// explicitCidrReservations returns the IPv4 CIDRs of the subnet held by
+// explicit reservations, which EC2 never assigns to nodes or pods on its own.
+// Prefix reservations are left out since prefix delegation hands them to pods
+func explicitCidrReservations(client *ec2.EC2, subnetID string) ([]*net.IPNet, error) {
+	var reserved []*net.IPNet
+	input := &ec2.GetSubnetCidrReservationsInput{SubnetId: aws.String(subnetID)}
+	for {
+		output, err := client.GetSubnetCidrReservations(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range output.SubnetIpv4CidrReservations {
+			if aws.StringValue(reservation.ReservationType) != ec2.SubnetCidrReservationTypeExplicit {
+				continue
+			}
+			if _, cidr, err := net.ParseCIDR(aws.StringValue(reservation.Cidr)); err == nil {
+				reserved = append(reserved, cidr)
+			}
+		}
+		if output.NextToken == nil {
+			return reserved, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// reservedIPs returns how many IPv4 addresses the reservations hold
+func reservedIPs(reservations []*net.IPNet) int {
+	reserved := 0
+	for _, cidr := range reservations {
+		ones, bits := cidr.Mask.Size()
+		reserved += 1 << uint(bits-ones)
+	}
+	return reserved
+}
+
+// cidrReservations returns the explicit CIDR reservations of a subnet,
+// looked up once per run and shared by the free IPs and /28 prefix counts.
+// Nothing is looked up with SUBNET_CIDR_RESERVATIONS=false
+func (s *subnetInventory) cidrReservations(client *ec2.EC2, subnetID string) ([]*net.IPNet, error) {
+	if !subnetCIDRReservations {
+		return nil, nil
+	}
+	if reservations, ok := s.reservations[subnetID]; ok {
+		return reservations, nil
+	}
+	reservations, err := explicitCidrReservations(client, subnetID)
+	if err != nil {
+		return nil, err
+	}
+	s.reservations[subnetID] = reservations
+	return reservations, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCIDRReservationsAreLookedUpOncePerRun(t *testing.T) {
+	defer func(enabled bool) { subnetCIDRReservations = enabled }(subnetCIDRReservations)
+
+	_, cidr, _ := net.ParseCIDR("10.0.1.0/27")
+	s := &subnetInventory{reservations: map[string][]*net.IPNet{"subnet-a": {cidr}}}
+
+	// a nil client would panic if the lookup went to EC2
+	subnetCIDRReservations = true
+	reservations, err := s.cidrReservations(nil, "subnet-a")
+	if err != nil {
+		t.Fatalf("cidrReservations() = %v", err)
+	}
+	if got := reservedIPs(reservations); got != 32 {
+		t.Errorf("reservedIPs() = %d, want 32", got)
+	}
+
+	subnetCIDRReservations = false
+	if reservations, err := s.cidrReservations(nil, "subnet-b"); err != nil || reservations != nil {
+		t.Errorf("cidrReservations() with SUBNET_CIDR_RESERVATIONS=false = %v, %v, want nothing looked up", reservations, err)
+	}
+}
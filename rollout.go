@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// scores written by the previous reconcile when ROLLOUT_MAX_CHANGES is set,
+// nil until the first one completes. Set by commitScores, so a deferred or
+// failed write steps from the same scores again next cycle
+var rolloutScores map[string]int
+
+// stepScores limits how far a single reconcile can move the priorities: at
+// most ROLLOUT_MAX_CHANGES ASGs take their new score, largest moves first,
+// and the others keep the score they had last cycle so a large reshuffle is
+// spread over several runs. New ASGs always take their computed score
+func stepScores(target map[string]int) map[string]int {
+	if rolloutScores == nil {
+		return target
+	}
+
+	stepped := make(map[string]int, len(target))
+	var pending []string
+	for name, score := range target {
+		current, ok := rolloutScores[name]
+		if !ok || current == score {
+			stepped[name] = score
+			continue
+		}
+		stepped[name] = current
+		pending = append(pending, name)
+	}
+
+	if len(pending) <= rolloutMaxChanges {
+		return target
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		di := abs(target[pending[i]] - rolloutScores[pending[i]])
+		dj := abs(target[pending[j]] - rolloutScores[pending[j]])
+		if di != dj {
+			return di > dj
+		}
+		return pending[i] < pending[j]
+	})
+	for _, name := range pending[:rolloutMaxChanges] {
+		stepped[name] = target[name]
+	}
+	fmt.Printf("Rolling out priority changes gradually: %d of %d ASG(s) updated this run\n", rolloutMaxChanges, len(pending))
+	return stepped
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
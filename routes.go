@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// subnetRouteTable returns the route table of the subnet: its explicit
+// association or, failing that, the main route table of its VPC
+func subnetRouteTable(client *ec2.EC2, subnetID, vpcID string) (*ec2.RouteTable, error) {
+	output, err := client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("association.subnet-id"),
+			Values: []*string{aws.String(subnetID)},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.RouteTables) > 0 {
+		return output.RouteTables[0], nil
+	}
+
+	output, err = client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpcID)}},
+			{Name: aws.String("association.main"), Values: []*string{aws.String("true")}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.RouteTables) == 0 {
+		return nil, fmt.Errorf("no route table found for subnet %s", subnetID)
+	}
+	return output.RouteTables[0], nil
+}
+
+// defaultRouteProblem returns why the route table can't get nodes to the
+// internet or the rest of the network, or an empty string when it has an
+// active default route through an internet gateway, NAT gateway or instance,
+// transit gateway or peering
+func defaultRouteProblem(table *ec2.RouteTable) string {
+	for _, route := range table.Routes {
+		if aws.StringValue(route.DestinationCidrBlock) != "0.0.0.0/0" {
+			continue
+		}
+		if aws.StringValue(route.State) != ec2.RouteStateActive {
+			return fmt.Sprintf("default route in %s is %s", aws.StringValue(table.RouteTableId), aws.StringValue(route.State))
+		}
+		switch {
+		case strings.HasPrefix(aws.StringValue(route.GatewayId), "igw-"),
+			route.NatGatewayId != nil,
+			route.TransitGatewayId != nil,
+			route.NetworkInterfaceId != nil,
+			route.InstanceId != nil,
+			route.VpcPeeringConnectionId != nil:
+			return ""
+		}
+		return fmt.Sprintf("default route in %s has no usable target", aws.StringValue(table.RouteTableId))
+	}
+	return fmt.Sprintf("route table %s has no default route", aws.StringValue(table.RouteTableId))
+}
+
+// routeProblem returns why the subnet is misrouted, or an empty string when
+// it has a usable default route. Results are cached for the run
+func (s *subnetInventory) routeProblem(subnetID string) (string, error) {
+	if problem, ok := s.routes[subnetID]; ok {
+		return problem, nil
+	}
+
+	table, err := subnetRouteTable(s.ec2[subnetID], subnetID, aws.StringValue(s.subnets[subnetID].VpcId))
+	if err != nil {
+		return "", err
+	}
+	problem := defaultRouteProblem(table)
+	s.routes[subnetID] = problem
+	return problem, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sigs.k8s.io/yaml"
+)
+
+// The rules document is a YAML map using the same keys as the environment
+// variables, e.g.
+//
+//	SCORING_WEIGHTS: free-ips=3,cost=1
+//	TOP_N: 20
+//
+// Its values override the environment, so a fleet of clusters can share a
+// centrally managed policy. Only the scoring and weighting settings in
+// remoteSettings can be set this way
+var (
+	rulesLoadedAt time.Time
+	rulesSettings map[string]string
+)
+
+// remoteSettings are the keys a rules document may set. Anything that runs a
+// command, calls out to an endpoint or changes credentials, namespaces or
+// the rules source itself stays in the environment
+var remoteSettings = map[string]bool{
+	"SCORING_MODE":                true,
+	"SCORING_WEIGHTS":             true,
+	"SPOT_INTERRUPTION_THRESHOLD": true,
+	"PREVIOUS_GENERATION_PENALTY": true,
+	"DEMOTED_SCORE":               true,
+	"STEP_SIZE":                   true,
+	"SMOOTHING_RUNS":              true,
+	"HYSTERESIS":                  true,
+	"ROLLOUT_MAX_CHANGES":         true,
+	"PRIORITY_NORMALIZATION":      true,
+	"PRIORITY_BAND_MAX":           true,
+	"TOP_N":                       true,
+	"CATCH_ALL":                   true,
+	"CATCH_ALL_PRIORITY":          true,
+	"CATCH_ALL_PATTERN":           true,
+	"STATIC_ENTRIES":              true,
+	"MIN_FREE_IPS":                true,
+	"MIN_FREE_IPS_ACTION":         true,
+	"SUBNET_AGGREGATION":          true,
+	"SUBNET_LOW_IP_THRESHOLD":     true,
+	"FREE_IPS_AS_PERCENT":         true,
+	"SHARED_SUBNET_ACCOUNTING":    true,
+	"SUBTRACT_PENDING_INSTANCES":  true,
+	"NODE_MAX_PODS":               true,
+	"NODE_IP_OVERHEAD":            true,
+	"EDGE_SUBNETS":                true,
+	"EXHAUSTION_HORIZON":          true,
+	"EXHAUSTION_WINDOW":           true,
+	"GPU_NODE_GROUPS":             true,
+	"INSTANCE_REFRESH_ACTION":     true,
+	"NAME_COLLATION":              true,
+	"SUBNET_CACHE_REFRESH":        true,
+}
+
+// validateRulesSource rejects rules fetched over plain HTTP unless they are
+// pinned to a checksum, as anyone on the path could rewrite them
+func validateRulesSource() error {
+	if strings.HasPrefix(rulesSource, "http://") && rulesSHA256 == "" {
+		return fmt.Errorf("RULES_SHA256 is required when RULES_SOURCE is a plain http:// URL")
+	}
+	if strings.HasPrefix(rulesSHA256Source, "http://") {
+		return fmt.Errorf("RULES_SHA256_SOURCE can't be a plain http:// URL")
+	}
+	return nil
+}
+
+// rulesLookup returns the value of a setting, preferring the rules document
+// over the environment
+func rulesLookup(settings map[string]string) func(string) string {
+	return func(key string) string {
+		if value, ok := settings[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	}
+}
+
+// fetchSource retrieves a document from an S3 object (s3://bucket/key), an
+// HTTP(S) endpoint, a file in a git repository
+// (git::https://host/repo.git//path/to/rules.yaml?ref=main) or a local path
+func fetchSource(source string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(source, "s3://"):
+		location := s3Location(source)
+		if len(location) != 2 {
+			return nil, fmt.Errorf("invalid S3 location %s", source)
+		}
+		output, err := s3.New(awsSession).GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(location[0]),
+			Key:    aws.String(location[1]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer output.Body.Close()
+		return io.ReadAll(output.Body)
+
+	case strings.HasPrefix(source, "https://"), strings.HasPrefix(source, "http://"):
+		client := &http.Client{Timeout: time.Minute}
+		response, err := client.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned %s", source, response.Status)
+		}
+		return io.ReadAll(response.Body)
+
+	case strings.HasPrefix(source, "git::"):
+		return fetchGitSource(strings.TrimPrefix(source, "git::"))
+
+	default:
+		return os.ReadFile(strings.TrimPrefix(source, "file://"))
+	}
+}
+
+// s3Location splits an s3://bucket/key URL into bucket and key
+func s3Location(source string) []string {
+	return strings.SplitN(strings.TrimPrefix(source, "s3://"), "/", 2)
+}
+
+// fetchGitSource shallow clones the repository and reads the file from it.
+// Needs the git binary in the image
+func fetchGitSource(source string) ([]byte, error) {
+	ref := ""
+	if i := strings.Index(source, "?ref="); i >= 0 {
+		ref = source[i+len("?ref="):]
+		source = source[:i]
+	}
+
+	// the path inside the repository follows the first // after the scheme
+	schemeEnd := strings.Index(source, "://") + len("://")
+	separator := strings.Index(source[schemeEnd:], "//")
+	if separator < 0 {
+		return nil, fmt.Errorf("missing //path in git source %s", source)
+	}
+	repository := source[:schemeEnd+separator]
+	path := source[schemeEnd+separator+2:]
+
+	dir, err := os.MkdirTemp("", "ca-autoconfig-rules")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, repository, dir)
+	if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("git clone %s: %v: %s", repository, err, strings.TrimSpace(string(output)))
+	}
+	return os.ReadFile(filepath.Join(dir, path))
+}
+
+// verifyChecksum checks the document against the pinned RULES_SHA256 or the
+// one published at RULES_SHA256_SOURCE, when either is configured
+func verifyChecksum(document []byte) error {
+	expected := rulesSHA256
+	if expected == "" && rulesSHA256Source != "" {
+		published, err := fetchSource(rulesSHA256Source)
+		if err != nil {
+			return fmt.Errorf("fetching checksum: %v", err)
+		}
+		// sha256sum format: "<hash>  <filename>"
+		fields := strings.Fields(string(published))
+		if len(fields) == 0 {
+			return fmt.Errorf("empty checksum at %s", rulesSHA256Source)
+		}
+		expected = fields[0]
+	}
+	if expected == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256(document)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// refreshRules fetches, verifies and applies the rules document. On any
+// error the settings in use are left untouched
+func refreshRules() error {
+	document, err := fetchSource(rulesSource)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(document); err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(document, &values); err != nil {
+		return fmt.Errorf("parsing rules: %v", err)
+	}
+	var rejected []string
+	for key := range values {
+		if !remoteSettings[key] {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return fmt.Errorf("settings not allowed in the rules document: %s", strings.Join(rejected, ", "))
+	}
+	settings := make(map[string]string, len(values))
+	for key, value := range values {
+		if number, ok := value.(float64); ok {
+			// YAML numbers come back as floats, keep integers readable
+			settings[key] = strconv.FormatFloat(number, 'f', -1, 64)
+		} else {
+			settings[key] = fmt.Sprint(value)
+		}
+	}
+
+	loadConfig(rulesLookup(settings))
+	if err := validateConfig(); err != nil {
+		loadConfig(rulesLookup(rulesSettings))
+		return err
+	}
+	if err := validateProfiles(rulesLookup(settings)); err != nil {
+		loadConfig(rulesLookup(rulesSettings))
+		return err
+	}
+
+	rulesSettings = settings
+	rulesLoadedAt = time.Now()
+	if debug {
+		fmt.Printf("DEBUG: loaded %d settings from %s\n", len(settings), rulesSource)
+	}
+	return nil
+}
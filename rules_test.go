@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRefreshRulesRejectsSettingsOutsideTheAllowlist(t *testing.T) {
+	defer func(source string, settings map[string]string) {
+		rulesSource, rulesSettings = source, settings
+		loadConfig(os.Getenv)
+	}(rulesSource, rulesSettings)
+	t.Setenv("REGION", "us-east-1")
+
+	tests := []struct {
+		name     string
+		document string
+		wantErr  string
+	}{
+		{name: "scoring settings", document: "TOP_N: 20\nSCORING_WEIGHTS: free-ips=3,cost=1\n"},
+		{name: "exec scorer", document: "TOP_N: 20\nSCORING_EXEC: /bin/sh -c id\n", wantErr: "SCORING_EXEC"},
+		{name: "webhook scorer", document: "SCORING_WEBHOOK_URL: https://example.com\n", wantErr: "SCORING_WEBHOOK_URL"},
+		{name: "rules source", document: "RULES_SOURCE: /tmp/other.yaml\n", wantErr: "RULES_SOURCE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.yaml")
+			if err := os.WriteFile(path, []byte(tt.document), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			rulesSource, rulesSettings = path, nil
+			topN = 0
+
+			err := refreshRules()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("refreshRules() = %v", err)
+				}
+				if topN != 20 {
+					t.Errorf("topN = %d, want 20", topN)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("refreshRules() = %v, want an error naming %s", err, tt.wantErr)
+			}
+			if topN != 0 || rulesSettings != nil {
+				t.Errorf("a rejected document was applied: topN = %d, settings = %v", topN, rulesSettings)
+			}
+		})
+	}
+}
+
+func TestValidateRulesSource(t *testing.T) {
+	defer func(source, sum, sumSource string) {
+		rulesSource, rulesSHA256, rulesSHA256Source = source, sum, sumSource
+	}(rulesSource, rulesSHA256, rulesSHA256Source)
+
+	tests := []struct {
+		name      string
+		source    string
+		sum       string
+		sumSource string
+		wantErr   bool
+	}{
+		{name: "https", source: "https://example.com/rules.yaml"},
+		{name: "https with published checksum", source: "https://example.com/rules.yaml", sumSource: "https://example.com/rules.yaml.sha256"},
+		{name: "http without checksum", source: "http://example.com/rules.yaml", wantErr: true},
+		{name: "http with pinned checksum", source: "http://example.com/rules.yaml", sum: "abc123"},
+		{name: "http with published checksum", source: "http://example.com/rules.yaml", sumSource: "https://example.com/rules.yaml.sha256", wantErr: true},
+		{name: "checksum over http", source: "s3://bucket/rules.yaml", sumSource: "http://example.com/rules.yaml.sha256", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rulesSource, rulesSHA256, rulesSHA256Source = tt.source, tt.sum, tt.sumSource
+			if err := validateRulesSource(); (err != nil) != tt.wantErr {
+				t.Errorf("validateRulesSource() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// when we started holding back a priority update, zero if we aren't
+var deferringSince time.Time
+
+// terminalActivityStatuses are the scaling activity states that mean the
+// activity is over
+var terminalActivityStatuses = map[string]bool{
+	autoscaling.ScalingActivityStatusCodeSuccessful: true,
+	autoscaling.ScalingActivityStatusCodeFailed:     true,
+	autoscaling.ScalingActivityStatusCodeCancelled:  true,
+}
+
+// scalingInProgress returns why the priorities shouldn't be reordered right
+// now: an ASG still launching instances, a scaling activity running or
+// finished within SCALING_SETTLE_WINDOW, or a recently created node that
+// isn't Ready yet. Returns an empty string when everything has settled
+func scalingInProgress(clientset kubernetes.Interface, matched []*asgInfo) (string, error) {
+	for _, asg := range matched {
+		if asg.DesiredCapacity > asg.InServiceInstances {
+			return fmt.Sprintf("ASG %s is launching %d instance(s)", asg.Name, asg.DesiredCapacity-asg.InServiceInstances), nil
+		}
+
+		output, err := asg.api().autoscaling.DescribeScalingActivities(&autoscaling.DescribeScalingActivitiesInput{
+			AutoScalingGroupName: aws.String(asg.Name),
+			MaxRecords:           aws.Int64(1),
+		})
+		if err != nil {
+			return "", err
+		}
+		for _, activity := range output.Activities {
+			if !terminalActivityStatuses[aws.StringValue(activity.StatusCode)] {
+				return fmt.Sprintf("scaling activity in progress on ASG %s", asg.Name), nil
+			}
+			if activity.EndTime != nil && time.Since(*activity.EndTime) < scalingSettleWindow {
+				return fmt.Sprintf("ASG %s scaled %s ago", asg.Name, time.Since(*activity.EndTime).Round(time.Second)), nil
+			}
+		}
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Items {
+		if time.Since(node.CreationTimestamp.Time) > scalingSettleWindow {
+			continue
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady && condition.Status != v1.ConditionTrue {
+				return fmt.Sprintf("node %s is not Ready yet", node.Name), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// deferPriorityUpdate reports whether the configmap update should be held
+// back this cycle, giving up after SCALING_DEFER_MAX so a cluster that is
+// always scaling still gets updates
+func deferPriorityUpdate(clientset kubernetes.Interface, matched []*asgInfo) bool {
+	reason, err := scalingInProgress(clientset, matched)
+	if err != nil {
+		fmt.Printf("Unable to check for scaling in progress: %v\n", err)
+		return false
+	}
+	if reason == "" {
+		deferringSince = time.Time{}
+		return false
+	}
+
+	if deferringSince.IsZero() {
+		deferringSince = time.Now()
+	}
+	if time.Since(deferringSince) >= scalingDeferMax {
+		fmt.Printf("Scaling still in progress (%s) but updates have been deferred for %s, applying\n", reason, scalingDeferMax)
+		deferringSince = time.Time{}
+		return false
+	}
+
+	fmt.Printf("Deferring priority update: %s\n", reason)
+	return true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeferredCycleKeepsScoreChangesForTheNextWrite(t *testing.T) {
+	defer func(scores map[string]int, deferring bool, deferMax time.Duration, since time.Time) {
+		previousScores, deferDuringScaling, scalingDeferMax, deferringSince = scores, deferring, deferMax, since
+	}(previousScores, deferDuringScaling, scalingDeferMax, deferringSince)
+	defer func(format, namespace, name string) {
+		outputFormat, caNamespace, caPriorityExpander = format, namespace, name
+	}(outputFormat, caNamespace, caPriorityExpander)
+
+	outputFormat, caNamespace, caPriorityExpander = outputConfigMap, "kube-system", "priorities"
+	deferDuringScaling, scalingDeferMax, deferringSince = true, time.Hour, time.Time{}
+	written := map[string]int{"workers-a": 10, "workers-b": 20}
+	previousScores = written
+	clientset := fake.NewSimpleClientset()
+	// computePriorities logs the changes of the plan before it is written
+	cycle := func(plan *priorityPlan) error {
+		logScoreChanges(plan.scores)
+		return writePriorities(plan, &subnetInventory{}, nil, clientset)
+	}
+
+	// a launching ASG holds back the update
+	scaling := &asgInfo{Name: "workers-a", DesiredCapacity: 3, InServiceInstances: 1}
+	changed := map[string]int{"workers-a": 30, "workers-b": 20}
+	plan := &priorityPlan{
+		matched:      []*asgInfo{scaling},
+		scores:       changed,
+		caPriorities: map[int][]string{30: {"workers-a"}, 20: {"workers-b"}},
+	}
+	if err := cycle(plan); err != nil {
+		t.Fatalf("deferred writePriorities() = %v", err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps(caNamespace).Get(context.Background(), caPriorityExpander, metav1.GetOptions{}); err == nil {
+		t.Fatal("the configmap was written during a deferred cycle")
+	}
+	if !reflect.DeepEqual(previousScores, written) {
+		t.Fatalf("previousScores = %v after a deferred cycle, want the last written %v", previousScores, written)
+	}
+
+	// the next cycle writes and only then moves the scores on
+	deferDuringScaling = false
+	if err := cycle(plan); err != nil {
+		t.Fatalf("writePriorities() = %v", err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps(caNamespace).Get(context.Background(), caPriorityExpander, metav1.GetOptions{}); err != nil {
+		t.Fatalf("configmap not written: %v", err)
+	}
+	if !reflect.DeepEqual(previousScores, changed) {
+		t.Errorf("previousScores = %v after a written cycle, want %v", previousScores, changed)
+	}
+}
+
+func TestDeferredCycleDoesNotAdvanceTheRollout(t *testing.T) {
+	defer func(scores, rollout map[string]int, maxChanges int) {
+		previousScores, rolloutScores, rolloutMaxChanges = scores, rollout, maxChanges
+	}(previousScores, rolloutScores, rolloutMaxChanges)
+	defer func(deferring bool, deferMax time.Duration, since time.Time) {
+		deferDuringScaling, scalingDeferMax, deferringSince = deferring, deferMax, since
+	}(deferDuringScaling, scalingDeferMax, deferringSince)
+	defer func(format, namespace, name string) {
+		outputFormat, caNamespace, caPriorityExpander = format, namespace, name
+	}(outputFormat, caNamespace, caPriorityExpander)
+
+	outputFormat, caNamespace, caPriorityExpander = outputConfigMap, "kube-system", "priorities"
+	deferDuringScaling, scalingDeferMax, deferringSince = true, time.Hour, time.Time{}
+	rolloutMaxChanges = 1
+	written := map[string]int{"workers-a": 10, "workers-b": 20}
+	previousScores, rolloutScores = written, written
+	clientset := fake.NewSimpleClientset()
+	target := map[string]int{"workers-a": 40, "workers-b": 30}
+	// computePriorities steps the scores before they are written
+	cycle := func(matched []*asgInfo) (map[string]int, error) {
+		scores := stepScores(target)
+		return scores, writePriorities(&priorityPlan{matched: matched, scores: scores}, &subnetInventory{}, nil, clientset)
+	}
+
+	scaling := &asgInfo{Name: "workers-a", DesiredCapacity: 3, InServiceInstances: 1}
+	if _, err := cycle([]*asgInfo{scaling}); err != nil {
+		t.Fatalf("deferred writePriorities() = %v", err)
+	}
+	if !reflect.DeepEqual(rolloutScores, written) {
+		t.Fatalf("rolloutScores = %v after a deferred cycle, want the last written %v", rolloutScores, written)
+	}
+
+	// the largest move goes first, the other one waits for the next run
+	deferDuringScaling = false
+	stepped, err := cycle(nil)
+	if err != nil {
+		t.Fatalf("writePriorities() = %v", err)
+	}
+	if want := map[string]int{"workers-a": 40, "workers-b": 20}; !reflect.DeepEqual(stepped, want) {
+		t.Fatalf("stepScores() = %v, want %v", stepped, want)
+	}
+	if !reflect.DeepEqual(rolloutScores, stepped) {
+		t.Errorf("rolloutScores = %v after a written cycle, want %v", rolloutScores, stepped)
+	}
+}
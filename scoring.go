@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scoringTimeoutDefault bounds how long an external scorer may take
+const scoringTimeoutDefault = 30 * time.Second
+
+type externalScoringRequest struct {
+	ASGs []*asgInfo `json:"asgs"`
+}
+
+type externalScoringResponse struct {
+	Scores map[string]int `json:"scores"`
+}
+
+// scorer assigns a score to every ASG it knows about
+type scorer interface {
+	String() string
+	scores(asgs []*asgInfo) (map[string]int, error)
+}
+
+// freeIPsScorer scores ASGs by the free IPs available to them, rounded down
+// to STEP_SIZE
+type freeIPsScorer struct{}
+
+func (freeIPsScorer) String() string { return "free-ips" }
+
+func (freeIPsScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	scores := make(map[string]int, len(asgs))
+	for _, asg := range asgs {
+		scores[asg.key()] = quantizeFreeIPs(asg.FreeIPs)
+	}
+	return scores, nil
+}
+
+// quantizeFreeIPs rounds free IPs down to a multiple of STEP_SIZE, so losing
+// a few addresses doesn't change the priority and rewrite the ConfigMap
+func quantizeFreeIPs(freeIPs int) int {
+	if stepSize <= 1 {
+		return freeIPs
+	}
+	return freeIPs / stepSize * stepSize
+}
+
+// webhookScorer posts the ASGs to a webhook
+type webhookScorer struct{ url string }
+
+func (s webhookScorer) String() string { return "webhook:" + s.url }
+
+func (s webhookScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	return externalScores(asgs, func(ctx context.Context, payload []byte) ([]byte, error) {
+		return webhookScores(ctx, s.url, payload)
+	})
+}
+
+// execScorer pipes the ASGs to a command
+type execScorer struct{ command string }
+
+func (s execScorer) String() string { return "exec:" + s.command }
+
+func (s execScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	return externalScores(asgs, func(ctx context.Context, payload []byte) ([]byte, error) {
+		return execScores(ctx, s.command, payload)
+	})
+}
+
+// scoringStrategies are the built-in scorers SCORING_MODE and SHADOW_SCORING
+// select by name. Adding a strategy only takes a scorer and an entry here
+var scoringStrategies = map[string]scorer{
+	scoringModeFreeIPs:       freeIPsScorer{},
+	scoringModeNodeHeadroom:  nodeHeadroomScorer{},
+	scoringModeWeighted:      weightedScorer{},
+	scoringModeSpotFirst:     spotFirstScorer{},
+	scoringModeCheapestFirst: cheapestFirstScorer{},
+	scoringModeBinPacking:    binPackingScorer{},
+}
+
+// scoringStrategyNames returns the names of the built-in scorers, sorted
+func scoringStrategyNames() []string {
+	names := make([]string, 0, len(scoringStrategies))
+	for name := range scoringStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// activeScorer returns the scorer configured by SCORING_WEBHOOK_URL,
+// SCORING_EXEC or SCORING_MODE, free IPs otherwise
+func activeScorer() scorer {
+	switch {
+	case scoringWebhookURL != "":
+		return webhookScorer{scoringWebhookURL}
+	case scoringExec != "":
+		return execScorer{scoringExec}
+	}
+	if s, ok := scoringStrategies[scoringMode]; ok {
+		return s
+	}
+	return freeIPsScorer{}
+}
+
+// parseScorer parses a scorer spec: the name of a built-in scorer,
+// webhook:<url> or exec:<command>
+func parseScorer(spec string) (scorer, error) {
+	if s, ok := scoringStrategies[spec]; ok {
+		return s, nil
+	}
+	switch {
+	case strings.HasPrefix(spec, "webhook:") && strings.TrimPrefix(spec, "webhook:") != "":
+		return webhookScorer{strings.TrimPrefix(spec, "webhook:")}, nil
+	case strings.HasPrefix(spec, "exec:") && strings.TrimSpace(strings.TrimPrefix(spec, "exec:")) != "":
+		return execScorer{strings.TrimPrefix(spec, "exec:")}, nil
+	}
+	return nil, fmt.Errorf("unknown scorer %q, expected %s, webhook:<url> or exec:<command>", spec, strings.Join(scoringStrategyNames(), ", "))
+}
+
+// scoreASGs returns the priority of every ASG using the active scorer
+func scoreASGs(asgs []*asgInfo) map[string]int {
+	return scoreWith(activeScorer(), asgs)
+}
+
+// scoreWith returns the priority of every ASG: the free IPs available to it
+// unless the scorer provides a score for it. Scorer errors fall back to free
+// IPs
+func scoreWith(s scorer, asgs []*asgInfo) map[string]int {
+	scores, _ := freeIPsScorer{}.scores(asgs)
+	if _, ok := s.(freeIPsScorer); ok {
+		return scores
+	}
+
+	external, err := s.scores(asgs)
+	if err != nil {
+		fmt.Printf("Error retrieving external scores, falling back to free IPs: %v\n", err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "scoring"}, 1)
+		return scores
+	}
+
+	for _, asg := range asgs {
+		score, ok := external[asg.key()]
+		if !ok {
+			score, ok = external[asg.Name]
+		}
+		if ok {
+			scores[asg.key()] = score
+		} else if debug {
+			fmt.Printf("DEBUG: no external score for %s, using free IPs\n", asg.Name)
+		}
+	}
+	return scores
+}
+
+// externalScores sends the discovered ASGs to an external scorer through
+// send and returns the scores it replies with
+func externalScores(asgs []*asgInfo, send func(context.Context, []byte) ([]byte, error)) (map[string]int, error) {
+	payload, err := json.Marshal(externalScoringRequest{ASGs: asgs})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scoringTimeout)
+	defer cancel()
+
+	output, err := send(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var response externalScoringResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("invalid scoring response: %v", err)
+	}
+	return response.Scores, nil
+}
+
+func webhookScores(ctx context.Context, url string, payload []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(response.Body); err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scoring webhook returned %s", response.Status)
+	}
+	return body.Bytes(), nil
+}
+
+func execScores(ctx context.Context, command string, payload []byte) ([]byte, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no scoring command given")
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// compileMatchRegex compiles a regex setting such as ASG_MATCH_REGEX, nil
+// when unset
+func compileMatchRegex(setting, pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %v", setting, pattern, err)
+	}
+	return re, nil
+}
+
+// asgExclusion is an ASG_EXCLUDES entry: a substring, or a regular
+// expression when written between slashes, e.g. /^ops-.*-bastion$/
+type asgExclusion struct {
+	substring string
+	re        *regexp.Regexp
+}
+
+// parseASGExcludes parses the comma separated ASG_EXCLUDES list
+func parseASGExcludes(value string) ([]asgExclusion, error) {
+	var exclusions []asgExclusion
+	for _, entry := range splitList(value) {
+		if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			re, err := regexp.Compile(entry[1 : len(entry)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid ASG_EXCLUDES pattern %q: %v", entry, err)
+			}
+			exclusions = append(exclusions, asgExclusion{re: re})
+			continue
+		}
+		exclusions = append(exclusions, asgExclusion{substring: entry})
+	}
+	return exclusions, nil
+}
+
+// excluded reports whether the ASG name matches the exclusion
+func (e asgExclusion) excluded(name string) bool {
+	if e.re != nil {
+		return e.re.MatchString(name)
+	}
+	return strings.Contains(name, e.substring)
+}
+
+// containsAny reports whether s contains any of the substrings, or true if
+// there are none
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return len(substrings) == 0
+}
+
+// asgNameMatches reports whether an ASG name is selected by ASG_CONTAINS, a
+// comma separated list of substrings any of which may match, and
+// ASG_MATCH_REGEX, and not dropped by ASG_EXCLUDES
+func asgNameMatches(name string) bool {
+	for _, exclusion := range asgExcludes {
+		if exclusion.excluded(name) {
+			return false
+		}
+	}
+	if !containsAny(name, asgContainsPatterns) {
+		return false
+	}
+	if asgMatchRegex != nil && !asgMatchRegex.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// tagRequirement is an ASG_TAG_SELECTOR entry: key=value requires the tag to
+// have that value, a bare key only requires the tag to be present
+type tagRequirement struct {
+	key      string
+	value    string
+	anyValue bool
+}
+
+// parseTagSelector parses a comma separated selector list such as
+// ASG_TAG_SELECTOR
+func parseTagSelector(setting, value string) ([]tagRequirement, error) {
+	var requirements []tagRequirement
+	for _, entry := range splitList(value) {
+		parts := strings.SplitN(entry, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if key == "" {
+			return nil, fmt.Errorf("invalid %s entry %q: missing key", setting, entry)
+		}
+		if len(parts) == 1 {
+			requirements = append(requirements, tagRequirement{key: key, anyValue: true})
+			continue
+		}
+		requirements = append(requirements, tagRequirement{key: key, value: strings.TrimSpace(parts[1])})
+	}
+	return requirements, nil
+}
+
+// asgTagsMatch reports whether the ASG tags satisfy every ASG_TAG_SELECTOR
+// requirement and don't opt the ASG out through IGNORE_TAG
+func asgTagsMatch(tags map[string]string) bool {
+	if ignore, _ := strconv.ParseBool(tags[ignoreTag]); ignore {
+		return false
+	}
+	for _, requirement := range asgTagSelector {
+		value, ok := tags[requirement.key]
+		if !ok || (!requirement.anyValue && value != requirement.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// asgAutoDiscovered reports whether the ASG carries the auto-discovery tags
+// the cluster-autoscaler looks for, always true unless CA_AUTO_DISCOVERY is
+// enabled
+func asgAutoDiscovered(tags map[string]string) bool {
+	if !caAutoDiscovery {
+		return true
+	}
+	_, enabled := tags[caEnabledTag]
+	_, cluster := tags[caClusterTag()]
+	return enabled && cluster
+}
+
+// asgDiscoveryFilters narrows DescribeAutoScalingGroups down server-side to
+// the groups with the ASG_TAG_SELECTOR tags and, when CA_AUTO_DISCOVERY is
+// enabled, the cluster's auto-discovery tag. Filters are ANDed but only one
+// tag-key filter is sent, the remaining requirements are checked on the
+// returned groups anyway
+func asgDiscoveryFilters() []*autoscaling.Filter {
+	var filters []*autoscaling.Filter
+	var tagKey string
+	if caAutoDiscovery {
+		tagKey = caClusterTag()
+	}
+	for _, requirement := range asgTagSelector {
+		if !requirement.anyValue {
+			filters = append(filters, &autoscaling.Filter{
+				Name:   aws.String("tag:" + requirement.key),
+				Values: aws.StringSlice([]string{requirement.value}),
+			})
+		} else if tagKey == "" {
+			tagKey = requirement.key
+		}
+	}
+	if tagKey != "" {
+		filters = append(filters, &autoscaling.Filter{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{tagKey})})
+	}
+	return filters
+}
+
+// asgSelected reports whether the ASG is selected by its name and its tags
+func asgSelected(asg *asgInfo) bool {
+	return asgNameMatches(asg.Name) && asgTagsMatch(asg.Tags) && asgAutoDiscovered(asg.Tags)
+}
+
+// launchMatches reports whether the ASG's launch template or launch
+// configuration, or one of its MixedInstancesPolicy override templates, is
+// selected by LT_CONTAINS and LT_MATCH_REGEX
+func launchMatches(asg *asgInfo) bool {
+	for _, name := range asg.launchNames() {
+		if !strings.Contains(name, ltContains) {
+			continue
+		}
+		if ltMatchRegex != nil && !ltMatchRegex.MatchString(name) {
+			continue
+		}
+		return true
+	}
+	return false
+}
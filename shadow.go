@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// evaluateShadow scores the ASGs with the SHADOW_SCORING strategy without
+// applying it and reports how its ordering diverges from the active one,
+// so a new policy can be evaluated before switching to it
+func evaluateShadow(asgs []*asgInfo, active map[string]int, status statusReport) {
+	shadow, err := parseScorer(shadowScoring)
+	if err != nil {
+		// validateConfig already rejects these
+		return
+	}
+	shadowScores := scoreWith(shadow, asgs)
+
+	activeRanking := rankASGs(active)
+	shadowRanking := rankASGs(shadowScores)
+	shadowRank := make(map[string]int, len(shadowRanking))
+	for i, name := range shadowRanking {
+		shadowRank[name] = i
+	}
+
+	moved := 0
+	for i, name := range activeRanking {
+		if shadowRank[name] != i {
+			moved++
+		}
+	}
+
+	// share of ASG pairs the two strategies order differently, 0 when they
+	// agree and 1 when one is the reverse of the other
+	discordant, pairs := 0, 0
+	for i := range activeRanking {
+		for j := i + 1; j < len(activeRanking); j++ {
+			pairs++
+			if shadowRank[activeRanking[i]] > shadowRank[activeRanking[j]] {
+				discordant++
+			}
+		}
+	}
+	divergence := 0.0
+	if pairs > 0 {
+		divergence = float64(discordant) / float64(pairs)
+	}
+
+	topMatch := 0.0
+	if len(activeRanking) > 0 && activeRanking[0] == shadowRanking[0] {
+		topMatch = 1
+	}
+
+	metrics.setGauge(metricShadowDivergence, profileLabels(), divergence)
+	metrics.setGauge(metricShadowMovedASGs, profileLabels(), float64(moved))
+	metrics.setGauge(metricShadowTopMatch, profileLabels(), topMatch)
+
+	fmt.Printf("Shadow scoring %s: %d ASG(s) ranked differently, divergence %.2f\n", shadow, moved, divergence)
+	if debug {
+		scored := make([]string, 0, len(shadowRanking))
+		for _, name := range shadowRanking {
+			scored = append(scored, fmt.Sprintf("%s=%d", name, shadowScores[name]))
+		}
+		fmt.Printf("DEBUG: shadow ordering: %s\n", strings.Join(scored, ", "))
+	}
+	// scores and divergence change every run, they're in the metrics
+	status.add("shadowScoring", fmt.Sprintf("%s ordering: %s", shadow, strings.Join(shadowRanking, ", ")))
+}
@@ -0,0 +1,36 @@
+package main
+
+// ways SHARED_SUBNET_ACCOUNTING can count subnets used by several ASGs
+const (
+	// every ASG gets all the free IPs of the subnet
+	sharedSubnetsFull = "full"
+	// the free IPs of the subnet are split evenly between the ASGs using it
+	sharedSubnetsSplit = "split"
+)
+
+// validSharedSubnetAccounting reports whether SHARED_SUBNET_ACCOUNTING is one
+// we know
+func validSharedSubnetAccounting() bool {
+	return sharedSubnetAccounting == sharedSubnetsFull || sharedSubnetAccounting == sharedSubnetsSplit
+}
+
+// apportionSharedSubnets recomputes the free IPs of the ASGs splitting every
+// subnet evenly between the ASGs using it, so groups in a shared VPC don't
+// all get credited with the same addresses. The shares are combined as set by
+// SUBNET_AGGREGATION and Subnets keeps the raw counts
+func apportionSharedSubnets(asgs []*asgInfo) {
+	sharers := make(map[string]int)
+	for _, asg := range asgs {
+		for subnetID := range asg.Subnets {
+			sharers[subnetID]++
+		}
+	}
+
+	for _, asg := range asgs {
+		shares := make([]int, 0, len(asg.Subnets))
+		for subnetID, freeIPs := range asg.Subnets {
+			shares = append(shares, freeIPs/sharers[subnetID])
+		}
+		asg.FreeIPs = aggregateFreeIPs(shares)
+	}
+}
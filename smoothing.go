@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// freeIPsWindow is the free IPs of an ASG over the last SMOOTHING_RUNS runs
+type freeIPsWindow struct {
+	values []int
+	// inventory of the run that recorded the last value, so profiles sharing
+	// it overwrite that value rather than adding another one
+	recordedBy *subnetInventory
+}
+
+// freeIPsWindows keeps the recent free IPs of every measured ASG
+var freeIPsWindows = make(map[string]*freeIPsWindow)
+
+// smoothFreeIPs replaces the free IPs of every measured ASG by their average
+// over the last SMOOTHING_RUNS runs, so nodes cycling or short-lived ENIs
+// don't reshuffle the priorities on every run. ASGs that are no longer
+// measured are forgotten
+func smoothFreeIPs(measured []*asgInfo, subnets *subnetInventory) {
+	seen := make(map[string]bool, len(measured))
+	for _, asg := range measured {
+		seen[asg.key()] = true
+		window, ok := freeIPsWindows[asg.key()]
+		if !ok {
+			window = &freeIPsWindow{}
+			freeIPsWindows[asg.key()] = window
+		}
+		if window.recordedBy == subnets {
+			window.values[len(window.values)-1] = asg.FreeIPs
+		} else {
+			window.values = append(window.values, asg.FreeIPs)
+			window.recordedBy = subnets
+		}
+		if len(window.values) > smoothingRuns {
+			window.values = window.values[len(window.values)-smoothingRuns:]
+		}
+
+		total := 0
+		for _, value := range window.values {
+			total += value
+		}
+		smoothed := total / len(window.values)
+		if debug && smoothed != asg.FreeIPs {
+			fmt.Printf("DEBUG: %s free IPs smoothed from %d to %d over %d run(s)\n", asg.Name, asg.FreeIPs, smoothed, len(window.values))
+		}
+		asg.FreeIPs = smoothed
+	}
+	for name, window := range freeIPsWindows {
+		if !seen[name] && window.recordedBy != subnets {
+			delete(freeIPsWindows, name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const scoringModeSpotFirst = "spot-first"
+
+// asgSpotShare returns the share of the ASG capacity launched as spot: all
+// of it when its launch template requests the spot market, otherwise the
+// share above the on-demand base of its MixedInstancesPolicy
+func asgSpotShare(asg *asgInfo) (float64, error) {
+	if asg.spotShare > 0 || asg.launchTemplateSpec == nil {
+		return asg.spotShare, nil
+	}
+	data, err := describeLaunchTemplateData(asg)
+	if err != nil {
+		return 0, err
+	}
+	if options := data.InstanceMarketOptions; options != nil && aws.StringValue(options.MarketType) == ec2.MarketTypeSpot {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// spotFirstScorer ranks every ASG launching spot capacity above every
+// on-demand one, free IPs ordering the ASGs within each group
+type spotFirstScorer struct{}
+
+func (spotFirstScorer) String() string { return scoringModeSpotFirst }
+
+func (spotFirstScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	spot := make(map[string]bool, len(asgs))
+	onDemandTop := 0
+	for _, asg := range asgs {
+		share, err := asgSpotShare(asg)
+		if err != nil {
+			return nil, fmt.Errorf("resolving the market of ASG %s: %v", asg.Name, err)
+		}
+		if share > 0 {
+			spot[asg.key()] = true
+		} else if freeIPs := quantizeFreeIPs(asg.FreeIPs); freeIPs > onDemandTop {
+			onDemandTop = freeIPs
+		}
+	}
+
+	scores := make(map[string]int, len(asgs))
+	for _, asg := range asgs {
+		scores[asg.key()] = quantizeFreeIPs(asg.FreeIPs)
+		if spot[asg.key()] {
+			scores[asg.key()] += onDemandTop + 1
+		}
+		if debug {
+			fmt.Printf("DEBUG: %s launches spot: %t, score %d\n", asg.Name, spot[asg.key()], scores[asg.key()])
+		}
+	}
+	return scores, nil
+}
+
+// spotProductDescription is the platform spot prices are looked up for
+const spotProductDescription = "Linux/UNIX"
+
+// asgSpotPrice is the average current spot price of the instance types of a
+// spot-backed ASG in the zones of its subnets, unknown for on-demand ASGs
+func asgSpotPrice(asg *asgInfo, cache *factorCache) (float64, bool, error) {
+	share, err := asgSpotShare(asg)
+	if err != nil || share == 0 {
+		return 0, false, err
+	}
+	types, err := asgInstanceTypes(asg)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := describeSpotPrices(asg.api(), types, cache); err != nil {
+		return 0, false, err
+	}
+
+	total, known := 0.0, 0
+	for _, zone := range asg.subnetZones {
+		for _, instanceType := range types {
+			if price, ok := cache.spotPrices[asg.api().region+"/"+zone+"/"+instanceType]; ok {
+				total += price
+				known++
+			}
+		}
+	}
+	if known == 0 {
+		return 0, false, nil
+	}
+	return total / float64(known), true, nil
+}
+
+// describeSpotPrices adds the current spot price of the instance types in
+// every zone of the region of the clients to the cache
+func describeSpotPrices(clients *awsClients, types []string, cache *factorCache) error {
+	if cache.spotPrices == nil {
+		cache.spotPrices = make(map[string]float64)
+	}
+	var missing []string
+	for _, instanceType := range types {
+		if !cache.spotPriced[clients.region+"/"+instanceType] {
+			missing = append(missing, instanceType)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// a start time of now returns the price in effect in every zone
+	err := clients.ec2.DescribeSpotPriceHistoryPages(&ec2.DescribeSpotPriceHistoryInput{
+		InstanceTypes:       aws.StringSlice(missing),
+		ProductDescriptions: aws.StringSlice([]string{spotProductDescription}),
+		StartTime:           aws.Time(time.Now()),
+	}, func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+		for _, price := range page.SpotPriceHistory {
+			value, err := strconv.ParseFloat(aws.StringValue(price.SpotPrice), 64)
+			if err != nil {
+				continue
+			}
+			cacheKey := clients.region + "/" + aws.StringValue(price.AvailabilityZone) + "/" + aws.StringValue(price.InstanceType)
+			if _, ok := cache.spotPrices[cacheKey]; !ok {
+				cache.spotPrices[cacheKey] = value
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return err
+	}
+	if cache.spotPriced == nil {
+		cache.spotPriced = make(map[string]bool)
+	}
+	for _, instanceType := range missing {
+		cache.spotPriced[clients.region+"/"+instanceType] = true
+	}
+	return nil
+}
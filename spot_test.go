@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestASGSpotPriceUsesMeasuredZones(t *testing.T) {
+	homeClients = &awsClients{region: "us-east-1"}
+	asg := &asgInfo{
+		Name:                  "workers-spot",
+		spotShare:             1,
+		overrideInstanceTypes: []string{"m5.large"},
+		Subnets:               map[string]int{"subnet-a": 10, "subnet-b": 10},
+		subnetZones:           map[string]string{"subnet-a": "us-east-1a", "subnet-b": "us-east-1b"},
+	}
+	cache := &factorCache{
+		spotPrices: map[string]float64{
+			"us-east-1/us-east-1a/m5.large": 0.04,
+			"us-east-1/us-east-1b/m5.large": 0.06,
+			"us-east-1/us-east-1c/m5.large": 1,
+		},
+		spotPriced: map[string]bool{"us-east-1/m5.large": true},
+	}
+
+	price, ok, err := asgSpotPrice(asg, cache)
+	if err != nil || !ok {
+		t.Fatalf("asgSpotPrice() = %v, %v, %v, want a price", price, ok, err)
+	}
+	if price < 0.0499 || price > 0.0501 {
+		t.Errorf("asgSpotPrice() = %v, want 0.05", price)
+	}
+}
+
+func TestASGSpotPriceUnknownForOnDemand(t *testing.T) {
+	homeClients = &awsClients{region: "us-east-1"}
+	asg := &asgInfo{Name: "workers", overrideInstanceTypes: []string{"m5.large"}}
+	if _, ok, err := asgSpotPrice(asg, &factorCache{}); ok || err != nil {
+		t.Errorf("asgSpotPrice() = %v, %v, want unknown", ok, err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// spotAdvisorTTL is how long the Spot Instance Advisor data is reused, it
+// only reflects the trend of the last month
+const spotAdvisorTTL = 6 * time.Hour
+
+// spotAdvisor keeps the last Spot Instance Advisor download across runs
+var spotAdvisor struct {
+	rates   map[string]map[string]float64
+	fetched time.Time
+}
+
+// spotAdvisorData is the part of the Spot Instance Advisor data we use
+type spotAdvisorData struct {
+	Ranges []struct {
+		Index int     `json:"index"`
+		Max   float64 `json:"max"`
+	} `json:"ranges"`
+	// region, operating system and instance type to the frequency of
+	// interruption range
+	SpotAdvisor map[string]map[string]map[string]struct {
+		Range int `json:"r"`
+	} `json:"spot_advisor"`
+}
+
+// asgSpotInterruption is the share of the ASG capacity expected to be
+// interrupted: the average interruption frequency the Spot Instance Advisor
+// gives its instance types, times the share of capacity it runs on spot
+func asgSpotInterruption(asg *asgInfo, cache *factorCache) (float64, bool, error) {
+	share, err := asgSpotShare(asg)
+	if err != nil {
+		return 0, false, err
+	}
+	if share == 0 {
+		return 0, true, nil
+	}
+	rate, ok, err := asgInterruptionRate(asg)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return rate * share, true, nil
+}
+
+// asgInterruptionRate is the average frequency of interruption, in percent,
+// the Spot Instance Advisor gives the instance types of the ASG in its
+// region, false when it lists none of them
+func asgInterruptionRate(asg *asgInfo) (float64, bool, error) {
+	rates, err := spotInterruptionRates()
+	if err != nil {
+		return 0, false, err
+	}
+	types, err := asgInstanceTypes(asg)
+	if err != nil {
+		return 0, false, err
+	}
+	total, known := 0.0, 0
+	for _, instanceType := range types {
+		if rate, ok := rates[asg.api().region][instanceType]; ok {
+			total += rate
+			known++
+		}
+	}
+	if known == 0 {
+		return 0, false, nil
+	}
+	return total / float64(known), true, nil
+}
+
+// spotInterruptionRates returns the upper bound, in percent, of the
+// frequency of interruption of every Linux instance type by region, from
+// SPOT_ADVISOR_URL. The data is downloaded once per spotAdvisorTTL, and the
+// previous download is kept when a refresh fails
+func spotInterruptionRates() (map[string]map[string]float64, error) {
+	if spotAdvisor.rates != nil && time.Since(spotAdvisor.fetched) < spotAdvisorTTL {
+		return spotAdvisor.rates, nil
+	}
+	rates, err := downloadSpotAdvisor()
+	if err != nil {
+		if spotAdvisor.rates == nil {
+			return nil, err
+		}
+		fmt.Printf("Error refreshing the Spot Instance Advisor data, using the one from %s: %v\n", spotAdvisor.fetched.Format(time.RFC3339), err)
+		metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "spot-advisor"}, 1)
+		return spotAdvisor.rates, nil
+	}
+	spotAdvisor.rates = rates
+	spotAdvisor.fetched = time.Now()
+	return rates, nil
+}
+
+// downloadSpotAdvisor downloads and parses SPOT_ADVISOR_URL
+func downloadSpotAdvisor() (map[string]map[string]float64, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Get(spotAdvisorURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", spotAdvisorURL, response.Status)
+	}
+
+	var data spotAdvisorData
+	if err := json.NewDecoder(response.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", spotAdvisorURL, err)
+	}
+	upperBounds := make(map[int]float64, len(data.Ranges))
+	for _, r := range data.Ranges {
+		upperBounds[r.Index] = r.Max
+	}
+	rates := make(map[string]map[string]float64, len(data.SpotAdvisor))
+	for region, systems := range data.SpotAdvisor {
+		rates[region] = make(map[string]float64, len(systems["Linux"]))
+		for instanceType, advice := range systems["Linux"] {
+			rates[region][instanceType] = upperBounds[advice.Range]
+		}
+	}
+	return rates, nil
+}
+
+// applySpotInterruptionDemotion demotes the spot-backed ASGs whose instance
+// types the Spot Instance Advisor gives an average frequency of interruption
+// above SPOT_INTERRUPTION_THRESHOLD percent, since their nodes keep being
+// reclaimed
+func applySpotInterruptionDemotion(matched []*asgInfo, scores map[string]int, status statusReport) {
+	var demoted []string
+	for _, asg := range matched {
+		share, err := asgSpotShare(asg)
+		if err == nil && share == 0 {
+			continue
+		}
+		var rate float64
+		var ok bool
+		if err == nil {
+			rate, ok, err = asgInterruptionRate(asg)
+		}
+		if err != nil {
+			fmt.Printf("Error resolving the spot interruption rate of ASG %s: %v\n", asg.Name, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "spot-advisor"}, 1)
+			continue
+		}
+		if ok && rate > float64(spotInterruptionThreshold) {
+			demoteASG(scores, status, asg.key(), fmt.Sprintf("spot interruption frequency above %d%%", spotInterruptionThreshold))
+			demoted = append(demoted, asg.key())
+		}
+	}
+	if debug && len(demoted) > 0 {
+		sort.Strings(demoted)
+		fmt.Printf("DEBUG: demoted for spot interruptions: %s\n", strings.Join(demoted, ", "))
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// staticEntry is a fixed pattern appended to the document at a priority,
+// from STATIC_ENTRIES or the catch-all
+type staticEntry struct {
+	priority int
+	pattern  string
+}
+
+// parseStaticEntries parses STATIC_ENTRIES, priority=pattern entries
+// separated by semicolons or newlines, since patterns may contain commas
+func parseStaticEntries(value string) ([]staticEntry, error) {
+	var entries []staticEntry
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid STATIC_ENTRIES entry %q, expected priority=pattern", entry)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATIC_ENTRIES entry %q: priority must be an integer", entry)
+		}
+		pattern := strings.TrimSpace(parts[1])
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid STATIC_ENTRIES pattern %q: %v", pattern, err)
+		}
+		entries = append(entries, staticEntry{priority: priority, pattern: pattern})
+	}
+	return entries, nil
+}
+
+// staticEntries returns the STATIC_ENTRIES by priority, in the order they're
+// written, followed by the catch-all when CATCH_ALL is set
+func staticEntries() map[int][]string {
+	// validateConfig already rejects invalid entries
+	entries, _ := parseStaticEntries(staticEntriesValue)
+	if catchAll {
+		entries = append(entries, staticEntry{priority: catchAllPriority, pattern: catchAllPattern})
+	}
+	byPriority := make(map[int][]string)
+	for _, entry := range entries {
+		byPriority[entry.priority] = append(byPriority[entry.priority], entry.pattern)
+	}
+	return byPriority
+}
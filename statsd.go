@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// statsdSink pushes metrics over UDP using the StatsD line protocol, or the
+// DogStatsD dialect when tagged is set
+type statsdSink struct {
+	addr   string
+	prefix string
+	tagged bool
+	tags   []string
+	// last value sent for every counter series, StatsD expects deltas
+	counters map[string]float64
+}
+
+var (
+	dogStatsDExporter *statsdSink
+	statsdExporter    *statsdSink
+)
+
+// statsdName sanitizes a label value so it can be part of a metric name
+func statsdName(value string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_").Replace(value)
+}
+
+// line renders a sample as a StatsD datagram. Plain StatsD has no tags, so
+// label values are appended to the metric name instead
+func (s *statsdSink) line(sample metricSample, value float64) string {
+	metricType := "g"
+	if sample.kind == counterMetric {
+		metricType = "c"
+	}
+
+	name := s.prefix + sample.name
+	var tags []string
+	if s.tagged {
+		for key, value := range sample.labels {
+			tags = append(tags, key+":"+value)
+		}
+		tags = append(tags, s.tags...)
+		sort.Strings(tags)
+	} else {
+		keys := make([]string, 0, len(sample.labels))
+		for key := range sample.labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name += "." + statsdName(sample.labels[key])
+		}
+	}
+
+	line := fmt.Sprintf("%s:%g|%s", name, value, metricType)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// publish sends every metric to the StatsD server
+func (s *statsdSink) publish() {
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		fmt.Printf("Unable to connect to StatsD at %s: %v\n", s.addr, err)
+		return
+	}
+	defer conn.Close()
+
+	for _, sample := range metrics.samples("") {
+		value := sample.value
+		if sample.kind == counterMetric {
+			key := seriesKey(sample.name, sample.labels)
+			value = sample.value - s.counters[key]
+			s.counters[key] = sample.value
+		}
+
+		// one datagram per metric keeps us well under the UDP payload limit
+		if _, err := conn.Write([]byte(s.line(sample, value))); err != nil {
+			fmt.Printf("Error sending metrics to StatsD at %s: %v\n", s.addr, err)
+			return
+		}
+	}
+}
+
+// publishDogStatsD sends every metric to the Datadog agent
+func publishDogStatsD() {
+	if dogStatsDExporter == nil {
+		dogStatsDExporter = &statsdSink{
+			addr:     net.JoinHostPort(datadogAgentHost, datadogStatsDPort),
+			tagged:   true,
+			counters: make(map[string]float64),
+		}
+		if datadogTags != "" {
+			dogStatsDExporter.tags = strings.Split(datadogTags, ",")
+		}
+	}
+	dogStatsDExporter.publish()
+}
+
+// publishStatsD sends every metric to a generic StatsD server
+func publishStatsD() {
+	if statsdExporter == nil {
+		statsdExporter = &statsdSink{
+			addr:     statsdAddr,
+			prefix:   statsdPrefix,
+			counters: make(map[string]float64),
+		}
+	}
+	statsdExporter.publish()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const statusKey = "status"
+
+// statusReport collects the findings of a run, grouped by section, to be
+// published next to the priorities in the configmap
+type statusReport map[string][]string
+
+func (s statusReport) add(section, entry string) {
+	s[section] = append(s[section], entry)
+}
+
+// render returns the report as a YAML document with sorted sections and
+// entries so unchanged findings don't cause configmap updates
+func (s statusReport) render() string {
+	sections := make([]string, 0, len(s))
+	for section := range s {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	var rendered strings.Builder
+	for _, section := range sections {
+		entries := append([]string(nil), s[section]...)
+		sort.Strings(entries)
+		fmt.Fprintf(&rendered, "%s:\n", section)
+		for _, entry := range entries {
+			fmt.Fprintf(&rendered, "  - %s\n", entry)
+		}
+	}
+	return rendered.String()
+}
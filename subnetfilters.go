@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// subnetExclusion returns why the subnet is left out of the free IPs of its
+// ASGs, or an empty string when it counts: it matches any of the
+// SUBNET_EXCLUDE_TAGS, e.g. subnets reserved for load balancers or
+// databases, or its availability zone, by name or ID, isn't in AZ_ALLOW or
+// is in AZ_DENY
+func subnetExclusion(subnet *ec2.Subnet) string {
+	tags := make(map[string]string, len(subnet.Tags))
+	for _, tag := range subnet.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	for _, requirement := range subnetExcludeTags {
+		if value, ok := tags[requirement.key]; ok && (requirement.anyValue || value == requirement.value) {
+			return "excluded by SUBNET_EXCLUDE_TAGS"
+		}
+	}
+
+	zone := aws.StringValue(subnet.AvailabilityZone)
+	zoneID := aws.StringValue(subnet.AvailabilityZoneId)
+	if len(azAllow) > 0 && !matchesAny(zone, azAllow) && !matchesAny(zoneID, azAllow) {
+		return "availability zone " + zone + " not in AZ_ALLOW"
+	}
+	if matchesAny(zone, azDeny) || matchesAny(zoneID, azDeny) {
+		return "availability zone " + zone + " in AZ_DENY"
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// weighSubnet scales the free IPs of the subnet by its SUBNET_WEIGHT_TAG tag,
+// e.g. 0.5 to count half of them. Subnets without the tag or with an invalid
+// weight count in full
+func weighSubnet(subnet *ec2.Subnet, freeIPs int) int {
+	for _, tag := range subnet.Tags {
+		if aws.StringValue(tag.Key) != subnetWeightTag {
+			continue
+		}
+		weight, err := strconv.ParseFloat(aws.StringValue(tag.Value), 64)
+		if err != nil || weight < 0 {
+			fmt.Printf("Ignoring invalid weight %q of subnet %s\n", aws.StringValue(tag.Value), aws.StringValue(subnet.SubnetId))
+			return freeIPs
+		}
+		if debug {
+			fmt.Printf("DEBUG: subnet %s is weighted %g\n", aws.StringValue(subnet.SubnetId), weight)
+		}
+		return int(float64(freeIPs) * weight)
+	}
+	return freeIPs
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const caEnabledTag = "k8s.io/cluster-autoscaler/enabled"
+
+// caClusterTag returns the per-cluster auto-discovery tag key
+func caClusterTag() string {
+	return "k8s.io/cluster-autoscaler/" + clusterName
+}
+
+// asgTagValue returns the value of the given tag on the ASG
+func asgTagValue(asg *asgInfo, key string) (string, bool) {
+	value, ok := asg.Tags[key]
+	return value, ok
+}
+
+// ensureASGTags creates or updates the given tags on the ASG, skipping the
+// call entirely when they are already in place. Returns whether anything
+// was changed
+func ensureASGTags(asg *asgInfo, desired map[string]string) (bool, error) {
+	var tags []*autoscaling.Tag
+	for key, value := range desired {
+		if current, ok := asgTagValue(asg, key); ok && current == value {
+			continue
+		}
+		tags = append(tags, &autoscaling.Tag{
+			ResourceId:        aws.String(asg.Name),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(key),
+			Value:             aws.String(value),
+			PropagateAtLaunch: aws.Bool(false),
+		})
+	}
+	if len(tags) == 0 {
+		return false, nil
+	}
+
+	_, err := asg.api().autoscaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{Tags: tags})
+	if err != nil {
+		return false, err
+	}
+
+	// keep the in-memory copy in sync so later checks in the same run see
+	// the new values
+	for _, tag := range tags {
+		asg.Tags[*tag.Key] = *tag.Value
+	}
+	return true, nil
+}
+
+// removeASGTags deletes the given tag keys from the ASG if present. Returns
+// whether anything was changed
+func removeASGTags(asg *asgInfo, keys []string) (bool, error) {
+	var tags []*autoscaling.Tag
+	for _, key := range keys {
+		if _, ok := asgTagValue(asg, key); !ok {
+			continue
+		}
+		tags = append(tags, &autoscaling.Tag{
+			ResourceId:   aws.String(asg.Name),
+			ResourceType: aws.String("auto-scaling-group"),
+			Key:          aws.String(key),
+		})
+	}
+	if len(tags) == 0 {
+		return false, nil
+	}
+
+	_, err := asg.api().autoscaling.DeleteTags(&autoscaling.DeleteTagsInput{Tags: tags})
+	if err != nil {
+		return false, err
+	}
+
+	for _, tag := range tags {
+		delete(asg.Tags, *tag.Key)
+	}
+	return true, nil
+}
+
+// reconcileCATags makes sure the cluster-autoscaler auto-discovery tags are
+// present on every matched ASG and absent from the excluded ones
+func reconcileCATags(matched, excluded []*asgInfo) {
+	desired := map[string]string{
+		caEnabledTag:   "true",
+		caClusterTag(): "owned",
+	}
+
+	for _, asg := range matched {
+		changed, err := ensureASGTags(asg, desired)
+		if err != nil {
+			fmt.Printf("Error tagging ASG %s: %v\n", asg.Name, err)
+		} else if changed {
+			fmt.Printf("Added cluster-autoscaler discovery tags to ASG: %s\n", asg.Name)
+		}
+	}
+
+	for _, asg := range excluded {
+		changed, err := removeASGTags(asg, []string{caEnabledTag, caClusterTag()})
+		if err != nil {
+			fmt.Printf("Error removing tags from ASG %s: %v\n", asg.Name, err)
+		} else if changed {
+			fmt.Printf("Removed cluster-autoscaler discovery tags from ASG: %s\n", asg.Name)
+		}
+	}
+}
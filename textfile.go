@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openMetricsLabelEscaper escapes label values as the exposition format
+// requires
+var openMetricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// histogramNames returns the names of every histogram recorded so far
+func (r *metricsRegistry) histogramNames() map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make(map[string]bool, len(r.histograms))
+	for _, h := range r.histograms {
+		names[h.name] = true
+	}
+	return names
+}
+
+// openMetricsFamily returns the metric family a flattened sample belongs to
+// and its OpenMetrics type
+func openMetricsFamily(sample metricSample, histograms map[string]bool) (string, string) {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if base := strings.TrimSuffix(sample.name, suffix); base != sample.name && histograms[base] {
+			return base, "histogram"
+		}
+	}
+	if sample.kind == counterMetric {
+		return strings.TrimSuffix(sample.name, "_total"), "counter"
+	}
+	return sample.name, "gauge"
+}
+
+// openMetricsText renders every metric in the OpenMetrics text format
+func openMetricsText() string {
+	histograms := metrics.histogramNames()
+
+	families := make(map[string][]metricSample)
+	types := make(map[string]string)
+	for _, sample := range metrics.samples("") {
+		family, metricType := openMetricsFamily(sample, histograms)
+		families[family] = append(families[family], sample)
+		types[family] = metricType
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var text strings.Builder
+	for _, family := range names {
+		fmt.Fprintf(&text, "# TYPE %s %s\n", family, types[family])
+		for _, sample := range families[family] {
+			keys := make([]string, 0, len(sample.labels))
+			for key := range sample.labels {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			text.WriteString(sample.name)
+			if len(keys) > 0 {
+				labels := make([]string, 0, len(keys))
+				for _, key := range keys {
+					labels = append(labels, key+`="`+openMetricsLabelEscaper.Replace(sample.labels[key])+`"`)
+				}
+				text.WriteString("{" + strings.Join(labels, ",") + "}")
+			}
+			text.WriteString(" " + strconv.FormatFloat(sample.value, 'g', -1, 64) + "\n")
+		}
+	}
+	text.WriteString("# EOF\n")
+	return text.String()
+}
+
+// writeMetricsTextfile writes the metrics to METRICS_TEXTFILE for
+// node-exporter's textfile collector. The file is replaced atomically so the
+// collector never reads a partial write
+func writeMetricsTextfile() {
+	tmp, err := os.CreateTemp(filepath.Dir(metricsTextfile), ".ca-autoconfig-*.prom.tmp")
+	if err != nil {
+		fmt.Printf("Unable to write metrics to %s: %v\n", metricsTextfile, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(openMetricsText()); err != nil {
+		tmp.Close()
+		fmt.Printf("Unable to write metrics to %s: %v\n", metricsTextfile, err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		fmt.Printf("Unable to write metrics to %s: %v\n", metricsTextfile, err)
+		return
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		fmt.Printf("Unable to write metrics to %s: %v\n", metricsTextfile, err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), metricsTextfile); err != nil {
+		fmt.Printf("Unable to write metrics to %s: %v\n", metricsTextfile, err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// how often the inspection UI reloads its data on its own
+const tuiRefresh = 30 * time.Second
+
+// orders the inspection UI can sort the ASGs by, cycled with "s"
+var tuiSortOrders = []string{"score", "name", "free IPs"}
+
+// tuiView is what the inspection UI shows: the ranking computed from the
+// current data, without writing the priorities, and the recent events
+type tuiView struct {
+	asgs     []*asgInfo
+	scores   map[string]int
+	events   []v1.Event
+	loadedAt time.Time
+	err      error
+
+	sortBy  int
+	filter  string
+	editing bool
+}
+
+// load discovers and scores the ASGs through the compute step of a
+// reconcile and fetches the events recorded on the configmap
+func (v *tuiView) load(clientset kubernetes.Interface) {
+	v.loadedAt = time.Now()
+	v.err = nil
+
+	asgs, err := awsSearchEC2ASGs()
+	if err != nil {
+		v.err = err
+		return
+	}
+
+	subnets := newSubnetInventory()
+	subnets.prefetch(asgs)
+	plan, err := computePriorities(asgs, subnets, clientset)
+	if err != nil {
+		v.err = err
+		return
+	}
+	v.asgs = plan.matched
+	v.scores = plan.scores
+
+	v.events = nil
+	if clientset == nil {
+		return
+	}
+	events, err := clientset.CoreV1().Events(caNamespace).List(context.Background(), metav1.ListOptions{
+		FieldSelector: "involvedObject.name=" + caPriorityExpander,
+	})
+	if err != nil {
+		v.err = err
+		return
+	}
+	v.events = events.Items
+	sort.Slice(v.events, func(i, j int) bool {
+		return v.events[i].LastTimestamp.After(v.events[j].LastTimestamp.Time)
+	})
+}
+
+// rows returns the ASGs matching the filter in the selected order
+func (v *tuiView) rows() []*asgInfo {
+	var rows []*asgInfo
+	for _, asg := range v.asgs {
+		if strings.Contains(asg.Name, v.filter) {
+			rows = append(rows, asg)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		switch tuiSortOrders[v.sortBy] {
+		case "name":
+			return rows[i].Name < rows[j].Name
+		case "free IPs":
+			return rows[i].FreeIPs > rows[j].FreeIPs
+		}
+		if v.scores[rows[i].key()] != v.scores[rows[j].key()] {
+			return v.scores[rows[i].key()] > v.scores[rows[j].key()]
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return rows
+}
+
+// render redraws the whole screen
+func (v *tuiView) render() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height < 10 {
+		width, height = 120, 40
+	}
+
+	ranks := make(map[string]int)
+	for i, name := range rankASGs(v.scores) {
+		ranks[name] = i + 1
+	}
+
+	var screen strings.Builder
+	fmt.Fprintf(&screen, "ca-autoconfig %s/%s, loaded %s, sort: %s", caNamespace, caPriorityExpander, v.loadedAt.Format("15:04:05"), tuiSortOrders[v.sortBy])
+	if v.filter != "" || v.editing {
+		fmt.Fprintf(&screen, ", filter: %s", v.filter)
+		if v.editing {
+			screen.WriteString("_")
+		}
+	}
+	screen.WriteString("\n")
+	if v.err != nil {
+		fmt.Fprintf(&screen, "error: %v\n", v.err)
+	}
+	screen.WriteString("\n")
+
+	fmt.Fprintf(&screen, "%-5s %-50s %8s %8s %8s %11s\n", "RANK", "ASG", "SCORE", "FREE IPS", "SUBNETS", "DES/MIN/MAX")
+	rows := v.rows()
+	maxRows := height - 17
+	for i, asg := range rows {
+		if i == maxRows {
+			fmt.Fprintf(&screen, "... %d more\n", len(rows)-maxRows)
+			break
+		}
+		fmt.Fprintf(&screen, "%-5d %-50s %8d %8d %8d %11s\n", ranks[asg.key()], asg.key(), v.scores[asg.key()], asg.FreeIPs, len(asg.Subnets),
+			fmt.Sprintf("%d/%d/%d", asg.DesiredCapacity, asg.MinSize, asg.MaxSize))
+	}
+
+	screen.WriteString("\nRecent events\n")
+	for i, event := range v.events {
+		if i == 8 {
+			break
+		}
+		fmt.Fprintf(&screen, "%s %-7s %-24s %s\n", event.LastTimestamp.Format("15:04:05"), event.Type, event.Reason, event.Message)
+	}
+
+	screen.WriteString("\nq quit, s sort, / filter, r refresh\n")
+
+	// raw mode needs explicit carriage returns, long lines are cut to fit
+	lines := strings.Split(screen.String(), "\n")
+	for i, line := range lines {
+		if len(line) > width {
+			lines[i] = line[:width]
+		}
+	}
+	fmt.Print("\x1b[H\x1b[2J" + strings.Join(lines, "\r\n"))
+}
+
+// handleKey applies a key press, returning false when the UI should exit
+// and true as second value when the data should be reloaded
+func (v *tuiView) handleKey(key byte) (bool, bool) {
+	if v.editing {
+		switch key {
+		case '\r', '\n', 27:
+			v.editing = false
+		case 127, 8:
+			if v.filter != "" {
+				v.filter = v.filter[:len(v.filter)-1]
+			}
+		default:
+			if key >= ' ' && key < 127 {
+				v.filter += string(key)
+			}
+		}
+		return true, false
+	}
+
+	switch key {
+	case 'q', 3:
+		return false, false
+	case 's':
+		v.sortBy = (v.sortBy + 1) % len(tuiSortOrders)
+	case '/':
+		v.editing = true
+		v.filter = ""
+	case 'r':
+		return true, true
+	}
+	return true, false
+}
+
+// runTUI runs the interactive inspection UI, returning the process exit code
+func runTUI() int {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		fmt.Println("tui needs an interactive terminal")
+		return exitConfigError
+	}
+	if err := validateConfig(); err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		return exitConfigError
+	}
+
+	// rankings are still useful without the events
+	var clientset kubernetes.Interface
+	if _, client, err := newKubernetesClient(); err != nil {
+		fmt.Printf("Unable to create Kubernetes client, events won't be shown: %v\n", err)
+	} else {
+		clientset = client
+	}
+
+	fmt.Println("Loading...")
+	view := &tuiView{}
+	view.load(clientset)
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Printf("Unable to set up the terminal: %v\n", err)
+		return exitGenericError
+	}
+	defer term.Restore(fd, state)
+	defer fmt.Print("\x1b[H\x1b[2J")
+
+	keys := make(chan byte)
+	go func() {
+		buffer := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buffer); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buffer[0]
+		}
+	}()
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	for {
+		view.render()
+		select {
+		case key, ok := <-keys:
+			if !ok {
+				return exitOK
+			}
+			running, reload := view.handleKey(key)
+			if !running {
+				return exitOK
+			}
+			if reload {
+				view.load(clientset)
+			}
+		case <-ticker.C:
+			view.load(clientset)
+		}
+	}
+}
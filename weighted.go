@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/pricing"
+)
+
+const (
+	scoringModeWeighted = "weighted"
+
+	// composite scores are spread over this many points above DEMOTED_SCORE
+	weightedScoreRange = 1000
+)
+
+// pricingRegions is where the Price List API is served from in each
+// partition. GovCloud has no endpoint of its own
+var pricingRegions = map[string]string{
+	endpoints.AwsPartitionID:   "us-east-1",
+	endpoints.AwsCnPartitionID: "cn-northwest-1",
+}
+
+// pricingRegion returns the Price List API region of the partition of
+// REGION, false when it has none
+func pricingRegion() (string, bool) {
+	region, ok := pricingRegions[awsPartition()]
+	return region, ok
+}
+
+// SCORING_WEIGHTS factors
+const (
+	factorFreeIPs          = "free-ips"
+	factorHeadroom         = "headroom"
+	factorCost             = "cost"
+	factorSpotInterruption = "spot-interruption"
+	factorSpotPrice        = "spot-price"
+)
+
+// scoringFactor is a signal the weighted scorer combines. value returns the
+// raw measure of an ASG, false when it's unknown. Lower is better for the
+// inverse ones
+type scoringFactor struct {
+	inverse bool
+	value   func(asg *asgInfo, cache *factorCache) (float64, bool, error)
+}
+
+var scoringFactors = map[string]scoringFactor{
+	factorFreeIPs: {value: func(asg *asgInfo, _ *factorCache) (float64, bool, error) {
+		return float64(asg.FreeIPs), true, nil
+	}},
+	factorHeadroom: {value: func(asg *asgInfo, _ *factorCache) (float64, bool, error) {
+		return float64(asg.MaxSize - asg.DesiredCapacity), true, nil
+	}},
+	factorCost:             {inverse: true, value: asgHourlyCost},
+	factorSpotInterruption: {inverse: true, value: asgSpotInterruption},
+	factorSpotPrice:        {inverse: true, value: asgSpotPrice},
+}
+
+// factorCache keeps what the factors look up during a run
+type factorCache struct {
+	prices     map[string]float64
+	pricingAPI *pricing.Pricing
+
+	// current spot prices by region, zone and instance type, and the region
+	// and instance types they were described for
+	spotPrices map[string]float64
+	spotPriced map[string]bool
+}
+
+// parseScoringWeights parses SCORING_WEIGHTS, a comma separated list of
+// factor=weight, free-ips=1 when empty
+func parseScoringWeights(value string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, entry := range splitList(value) {
+		parts := strings.SplitN(entry, "=", 2)
+		factor := strings.TrimSpace(parts[0])
+		if _, ok := scoringFactors[factor]; !ok {
+			return nil, fmt.Errorf("invalid SCORING_WEIGHTS entry %q: unknown factor, expected free-ips, headroom, cost, spot-interruption or spot-price", entry)
+		}
+		if len(parts) == 1 {
+			return nil, fmt.Errorf("invalid SCORING_WEIGHTS entry %q: missing weight", entry)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid SCORING_WEIGHTS entry %q: the weight must be a non-negative number", entry)
+		}
+		weights[factor] = weight
+	}
+	if len(weights) == 0 {
+		weights[factorFreeIPs] = 1
+	}
+	return weights, nil
+}
+
+// weightedScorer combines the SCORING_WEIGHTS factors into a single score.
+// Every factor is normalized between its worst and best ASG, so the weights
+// set how much each one counts regardless of its unit, and ASGs a factor
+// knows nothing about sit halfway
+type weightedScorer struct{}
+
+func (weightedScorer) String() string { return scoringModeWeighted }
+
+func (weightedScorer) scores(asgs []*asgInfo) (map[string]int, error) {
+	weights, err := parseScoringWeights(scoringWeights)
+	if err != nil {
+		return nil, err
+	}
+	factors := make([]string, 0, len(weights))
+	for factor := range weights {
+		factors = append(factors, factor)
+	}
+	sort.Strings(factors)
+
+	cache := &factorCache{prices: make(map[string]float64)}
+	composite := make(map[string]float64, len(asgs))
+	total := 0.0
+	for _, factor := range factors {
+		weight := weights[factor]
+		if weight == 0 {
+			continue
+		}
+		if _, ok := pricingRegion(); factor == factorCost && !ok {
+			fmt.Printf("The Price List API isn't available in the %s partition, leaving the cost scoring factor out\n", awsPartition())
+			continue
+		}
+		normalized, err := normalizeFactor(scoringFactors[factor], asgs, cache)
+		if err != nil {
+			fmt.Printf("Error resolving the %s scoring factor, leaving it out: %v\n", factor, err)
+			metrics.incCounter(metricErrorsTotal, map[string]string{"stage": "scoring"}, 1)
+			continue
+		}
+		for _, asg := range asgs {
+			composite[asg.key()] += weight * normalized[asg.key()]
+		}
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no scoring factor could be resolved")
+	}
+
+	scores := make(map[string]int, len(asgs))
+	for _, asg := range asgs {
+		scores[asg.key()] = demotedScore + 1 + int(math.Round(composite[asg.key()]/total*weightedScoreRange))
+		if debug {
+			fmt.Printf("DEBUG: %s weighted score %d\n", asg.Name, scores[asg.key()])
+		}
+	}
+	return scores, nil
+}
+
+// normalizeFactor returns the factor of every ASG scaled between 0 for the
+// worst ASG and 1 for the best one
+func normalizeFactor(factor scoringFactor, asgs []*asgInfo, cache *factorCache) (map[string]float64, error) {
+	values := make(map[string]float64, len(asgs))
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, asg := range asgs {
+		value, ok, err := factor.value(asg, cache)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		values[asg.key()] = value
+		lowest = math.Min(lowest, value)
+		highest = math.Max(highest, value)
+	}
+
+	normalized := make(map[string]float64, len(asgs))
+	for _, asg := range asgs {
+		value, ok := values[asg.key()]
+		switch {
+		case !ok:
+			normalized[asg.key()] = 0.5
+		case highest == lowest:
+			normalized[asg.key()] = 1
+		case factor.inverse:
+			normalized[asg.key()] = (highest - value) / (highest - lowest)
+		default:
+			normalized[asg.key()] = (value - lowest) / (highest - lowest)
+		}
+	}
+	return normalized, nil
+}
+
+// asgHourlyCost is the average on-demand Linux price of the instance types
+// the ASG can launch in its region
+func asgHourlyCost(asg *asgInfo, cache *factorCache) (float64, bool, error) {
+	types, err := asgInstanceTypes(asg)
+	if err != nil {
+		return 0, false, err
+	}
+	total, known := 0.0, 0
+	for _, instanceType := range types {
+		price, err := onDemandPrice(cache, asg.api().region, instanceType)
+		if err != nil {
+			return 0, false, err
+		}
+		if price > 0 {
+			total += price
+			known++
+		}
+	}
+	if known == 0 {
+		return 0, false, nil
+	}
+	return total / float64(known), true, nil
+}
+
+// onDemandPrice returns the hourly on-demand Linux price of the instance type
+// in the region from the Price List API, 0 when it isn't listed
+func onDemandPrice(cache *factorCache, region, instanceType string) (float64, error) {
+	cacheKey := region + "/" + instanceType
+	if price, ok := cache.prices[cacheKey]; ok {
+		return price, nil
+	}
+	if cache.pricingAPI == nil {
+		apiRegion, ok := pricingRegion()
+		if !ok {
+			return 0, fmt.Errorf("the Price List API isn't available in the %s partition", awsPartition())
+		}
+		cache.pricingAPI = pricing.New(homeClients.session, &aws.Config{Region: aws.String(apiRegion)})
+	}
+
+	filters := map[string]string{
+		"instanceType":    instanceType,
+		"regionCode":      region,
+		"operatingSystem": "Linux",
+		"tenancy":         "Shared",
+		"preInstalledSw":  "NA",
+		"capacitystatus":  "Used",
+	}
+	input := &pricing.GetProductsInput{ServiceCode: aws.String("AmazonEC2"), MaxResults: aws.Int64(10)}
+	for field, value := range filters {
+		input.Filters = append(input.Filters, &pricing.Filter{
+			Field: aws.String(field),
+			Type:  aws.String(pricing.FilterTypeTermMatch),
+			Value: aws.String(value),
+		})
+	}
+	output, err := cache.pricingAPI.GetProducts(input)
+	if err != nil {
+		return 0, err
+	}
+
+	price := 0.0
+	for _, product := range output.PriceList {
+		if price = productOnDemandPrice(product); price > 0 {
+			break
+		}
+	}
+	cache.prices[cacheKey] = price
+	return price, nil
+}
+
+// productOnDemandPrice digs the USD hourly price out of a Price List product,
+// found under terms.OnDemand.<offer>.priceDimensions.<rate>.pricePerUnit
+func productOnDemandPrice(product aws.JSONValue) float64 {
+	terms, _ := product["terms"].(map[string]interface{})
+	offers, _ := terms["OnDemand"].(map[string]interface{})
+	for _, offer := range offers {
+		offer, _ := offer.(map[string]interface{})
+		dimensions, _ := offer["priceDimensions"].(map[string]interface{})
+		for _, dimension := range dimensions {
+			dimension, _ := dimension.(map[string]interface{})
+			perUnit, _ := dimension["pricePerUnit"].(map[string]interface{})
+			usd, _ := perUnit["USD"].(string)
+			if price, err := strconv.ParseFloat(usd, 64); err == nil && price > 0 {
+				return price
+			}
+		}
+	}
+	return 0
+}
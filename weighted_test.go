@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPricingRegionFollowsThePartition(t *testing.T) {
+	defer loadConfig(os.Getenv)
+
+	tests := []struct {
+		region     string
+		want       string
+		wantOK     bool
+		cheapestOK bool
+	}{
+		{region: "eu-west-1", want: "us-east-1", wantOK: true, cheapestOK: true},
+		{region: "cn-north-1", want: "cn-northwest-1", wantOK: true, cheapestOK: true},
+		{region: "us-gov-west-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			loadConfig(func(key string) string {
+				switch key {
+				case "REGION":
+					return tt.region
+				case "SCORING_MODE":
+					return scoringModeCheapestFirst
+				}
+				return ""
+			})
+			if got, ok := pricingRegion(); got != tt.want || ok != tt.wantOK {
+				t.Errorf("pricingRegion() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+			if err := validateConfig(); (err == nil) != tt.cheapestOK {
+				t.Errorf("validateConfig() with SCORING_MODE=%s = %v", scoringModeCheapestFirst, err)
+			}
+		})
+	}
+}